@@ -1,14 +1,167 @@
 package cli
 
 import (
+	"errors"
+	"fmt"
 	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
 	"techiecaro/remblob/core"
 	"techiecaro/remblob/editor"
+	"techiecaro/remblob/storage"
+	"time"
 
+	"github.com/alecthomas/kong"
 	"github.com/willabides/kongplete"
 )
 
+// notModifiedExitCode tells scripts the blob didn't change since --if-modified-since
+const notModifiedExitCode = 3
+
+// missingExitCode tells scripts the blob doesn't exist. Failing to check exits with 1, as any other error
+const missingExitCode = 2
+
+func exitIfNotModified(err error) error {
+	if errors.Is(err, storage.ErrNotModified) {
+		fmt.Fprintln(os.Stderr, "Not modified, skipping")
+		os.Exit(notModifiedExitCode)
+	}
+	return err
+}
+
+// storageFlags configure the storage backends.
+type storageFlags struct {
+	S3Provider      string `name:"s3-provider" enum:"aws,spaces,b2,wasabi" default:"aws" env:"REMBLOB_S3_PROVIDER" help:"Preset for S3-compatible services (aws, spaces, b2, wasabi)."`
+	CredentialsFile string `name:"credentials-file" type:"path" help:"Shared AWS credentials file to use instead of the default one."`
+}
+
+func (s storageFlags) getStorageOptions() storage.Options {
+	return storage.Options{
+		S3Provider:      s.S3Provider,
+		CredentialsFile: s.CredentialsFile,
+		Schemes:         getSchemeDefaults(),
+	}
+}
+
+// commonFlags are shared by the commands reading a blob.
+type commonFlags struct {
+	storageFlags
+
+	Encoding         string        `enum:"auto,utf-8,utf-16le,utf-16be" default:"auto" help:"Text encoding of the source. Auto detects UTF-16 by its BOM."`
+	InCmd            string        `name:"in-cmd" help:"Shell command converting the source (stdin to stdout) before editing."`
+	IfModifiedSince  time.Time     `name:"if-modified-since" help:"Only proceed if the blob was modified after this RFC3339 time. Exits with 3 otherwise."`
+	Delimiter        string        `help:"Delimiter of CSV/TSV fields, a single character or \"tab\". Detected from the header by default."`
+	CSVPlain         bool          `name:"csv-plain" help:"Edit CSV/TSV as plain text, without normalizing its quoting or validating it."`
+	LineRange        string        `name:"line-range" help:"Edit only the lines START:END, counted from 1, merging them back into the rest. END may be left out for the last line."`
+	EditorTimeout    time.Duration `name:"editor-timeout" help:"Abort without writing when the editor, or --filter-cmd, runs longer than this, e.g. 10m."`
+	NoFollowSymlinks bool          `name:"no-follow-symlinks" help:"Refuse to edit local files which are symbolic links, instead of editing their target."`
+	Lenient          bool          `help:"Read .gz blobs which aren't Gzip compressed as plain text, with a warning, instead of failing."`
+
+	SourceCompression string `name:"source-compression" enum:"auto,none,gzip,snappy" default:"auto" help:"Compression of the source (auto, none, gzip, snappy). Auto tells it by the extension."`
+	VersionID         string `name:"version-id" help:"Read this version of an S3 object, as listed by the versions command, instead of the latest."`
+
+	MaxRowsInteractive int  `name:"max-rows-interactive" default:"1000000" help:"Refuse to open CSV/TSV with more lines than this in the editor. Zero means no limit."`
+	Yes                bool `help:"Open the editor regardless of --max-rows-interactive."`
+}
+
+func (c commonFlags) getOptions() (core.Options, error) {
+	delimiter, err := c.getDelimiter()
+	if err != nil {
+		return core.Options{}, err
+	}
+
+	firstLine, lastLine, err := c.getLineRange()
+	if err != nil {
+		return core.Options{}, err
+	}
+
+	storageOptions := c.getStorageOptions()
+	storageOptions.IfModifiedSince = c.IfModifiedSince
+	storageOptions.NoFollowSymlinks = c.NoFollowSymlinks
+	storageOptions.VersionID = c.VersionID
+
+	options := core.Options{
+		Storage:      storageOptions,
+		Encoding:     c.Encoding,
+		InCommand:    c.InCmd,
+		CSVDelimiter: delimiter,
+		CSVPlain:     c.CSVPlain,
+		Lenient:      c.Lenient,
+	}
+	options.SourceCompression = c.SourceCompression
+	options.FirstLine, options.LastLine = firstLine, lastLine
+	if !c.Yes {
+		options.MaxRowsInteractive = c.MaxRowsInteractive
+	}
+	return options, nil
+}
+
+// getLineRange parses START:END, or START: for up to the last line. Zeros when not given
+func (c commonFlags) getLineRange() (int, int, error) {
+	if c.LineRange == "" {
+		return 0, 0, nil
+	}
+
+	invalid := fmt.Errorf("Line range must be START:END, e.g. 10:20, or START: up to the last line: %#v", c.LineRange)
+	parts := strings.SplitN(c.LineRange, ":", 2)
+	if len(parts) != 2 {
+		return 0, 0, invalid
+	}
+	first, err := strconv.Atoi(parts[0])
+	if err != nil || first < 1 {
+		return 0, 0, invalid
+	}
+	if parts[1] == "" {
+		return first, 0, nil
+	}
+	last, err := strconv.Atoi(parts[1])
+	if err != nil || last < first {
+		return 0, 0, invalid
+	}
+	return first, last, nil
+}
+
+func (c commonFlags) getDelimiter() (rune, error) {
+	if c.Delimiter == "tab" {
+		return '\t', nil
+	}
+
+	delimiter := []rune(c.Delimiter)
+	switch len(delimiter) {
+	case 0:
+		return 0, nil
+	case 1:
+		return delimiter[0], nil
+	default:
+		return 0, fmt.Errorf("Delimiter must be a single character: %#v", c.Delimiter)
+	}
+}
+
 type editCmd struct {
+	commonFlags
+
+	OutCmd    string `name:"out-cmd" help:"Shell command converting the edited file (stdin to stdout) before writing."`
+	FilterCmd string `name:"filter-cmd" help:"Shell command editing the file (stdin to stdout) instead of the editor."`
+	Stamp     bool   `help:"Record who edited the blob and when in its metadata."`
+	Comment   string `help:"Record why the blob was edited in its metadata. Implies --stamp."`
+
+	TrimTrailingNewline   bool `xor:"newline" help:"Strip a single trailing newline before comparing and writing."`
+	EnsureTrailingNewline bool `xor:"newline" help:"End the file with exactly one newline before comparing and writing."`
+	IgnoreWhitespace      bool `help:"Don't write when only whitespace changed. The edited file is written as is otherwise."`
+	CSVStrict             bool `name:"csv-strict" help:"Require all CSV records to have the same number of fields."`
+	ForceBinary           bool `help:"Edit the blob even if it looks binary."`
+	Mkdir                 bool `help:"Create missing parent directories of a local destination."`
+	KeepCompression       bool `help:"Compress the destination like the source when its extension doesn't name a compression."`
+	PreserveMtime         bool `name:"preserve-mtime" help:"Keep the modification time of a local destination being overwritten."`
+
+	BackupSuffix string `name:"backup-suffix" help:"Keep a copy of an overwritten local file, named with this suffix, e.g. \"~\"."`
+
+	ContentDisposition     string `name:"content-disposition" help:"Set the Content-Disposition header of an S3 destination, e.g. \"attachment; filename=blob.json\". Kept from the source otherwise."`
+	DestinationCompression string `name:"destination-compression" enum:"auto,none,gzip,snappy" default:"auto" help:"Compression of the destination (auto, none, gzip, snappy). Auto tells it by the extension."`
+	Overwrite              string `enum:"always,never,if-different" default:"always" help:"When to write the destination (always, never if it exists, if-different from its content)."`
+
 	SourcePath      url.URL  `arg:"" name:"source_path" help:"Location of the file to edit." predictor:"path"`
 	DestinationPath *url.URL `arg:"" name:"destination_path" optional:"" help:"Final location of the edited file, if different." predictor:"path"`
 }
@@ -20,24 +173,154 @@ func (e editCmd) GetDestinationPath() url.URL {
 	return e.SourcePath
 }
 
+func (e editCmd) getTrailingNewline() string {
+	switch {
+	case e.TrimTrailingNewline:
+		return core.NewlineTrim
+	case e.EnsureTrailingNewline:
+		return core.NewlineEnsure
+	default:
+		return core.NewlinePreserve
+	}
+}
+
+func (e editCmd) getEditor() editor.Editor {
+	if e.FilterCmd != "" {
+		return editor.FilterEditor{Command: e.FilterCmd, Timeout: e.EditorTimeout}
+	}
+	return getEnvEditor(e.SourcePath, e.EditorTimeout)
+}
+
 func (e editCmd) Run() error {
-	localEditor := editor.EnvEditor{}
-	return core.Edit(e.SourcePath, e.GetDestinationPath(), localEditor)
+	localEditor := e.getEditor()
+	options, err := e.getOptions()
+	if err != nil {
+		return err
+	}
+	if e.FilterCmd != "" {
+		options.MaxRowsInteractive = 0 // Not interactive
+	}
+	options.OutCommand = e.OutCmd
+	options.Stamp = e.Stamp
+	options.Comment = e.Comment
+	options.TrailingNewline = e.getTrailingNewline()
+	options.IgnoreWhitespace = e.IgnoreWhitespace
+	options.CSVStrict = e.CSVStrict
+	options.ForceBinary = e.ForceBinary
+	options.Storage.MakeDirs = e.Mkdir
+	options.KeepCompression = e.KeepCompression
+	options.Storage.BackupSuffix = e.BackupSuffix
+	options.Storage.PreserveMtime = e.PreserveMtime
+	options.DestinationCompression = e.DestinationCompression
+	options.ContentDisposition = e.ContentDisposition
+	options.Overwrite = e.Overwrite
+	return exitIfNotModified(core.Edit(e.SourcePath, e.GetDestinationPath(), localEditor, options))
 }
 
 type viewCmd struct {
+	commonFlags
+
+	Stdout   bool   `help:"Print the content to stdout instead of opening an editor."`
+	Pager    bool   `help:"Page through the content with $PAGER instead of opening an editor. Uses the editor when PAGER isn't set."`
+	CacheDir string `name:"cache-dir" type:"path" help:"Keep downloaded blobs here, reusing them while the blob's version (ETag) doesn't change."`
+
+	LenientGzip bool `name:"lenient-gzip" help:"Show the start of truncated .gz blobs, with a warning, instead of failing."`
+
 	SourcePath url.URL `arg:"" name:"source_path" help:"Location of the file to view." predictor:"path"`
 }
 
 func (v viewCmd) Run() error {
-	localEditor := editor.EnvEditor{}
-	return core.View(v.SourcePath, localEditor)
+	options, err := v.getOptions()
+	if err != nil {
+		return err
+	}
+	options.CacheDir = v.CacheDir
+	options.LenientGzip = v.LenientGzip
+	if v.Stdout {
+		return exitIfNotModified(core.Print(v.SourcePath, os.Stdout, options))
+	}
+
+	var localEditor editor.Editor = getEnvEditor(v.SourcePath, v.EditorTimeout)
+	if pager := os.Getenv("PAGER"); v.Pager && pager != "" {
+		localEditor = editor.Pager{Command: pager}
+		options.MaxRowsInteractive = 0 // Pagers cope with huge tables
+	}
+	return exitIfNotModified(core.View(v.SourcePath, localEditor, options))
+}
+
+type existsCmd struct {
+	storageFlags
+
+	SourcePath url.URL `arg:"" name:"source_path" help:"Location of the file to check." predictor:"path"`
+}
+
+func (e existsCmd) Run() error {
+	code, err := e.check()
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		os.Exit(code)
+	}
+	return nil
+}
+
+// check returns the exit code telling whether the blob exists. Failing to check is an error
+func (e existsCmd) check() (int, error) {
+	exists, err := core.Exists(e.SourcePath, core.Options{Storage: e.getStorageOptions()})
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return missingExitCode, nil
+	}
+	return 0, nil
+}
+
+type setMetaCmd struct {
+	storageFlags
+
+	ContentType string            `name:"content-type" help:"New content type of the blob. Kept when not given."`
+	Meta        map[string]string `name:"meta" help:"User defined metadata to set, as key=value. An empty value removes the key."`
+
+	SourcePath url.URL `arg:"" name:"source_path" help:"Location of the blob to update." predictor:"path"`
+}
+
+func (s setMetaCmd) Run() error {
+	options := core.Options{Storage: s.getStorageOptions()}
+	return core.UpdateMetadata(s.SourcePath, s.ContentType, s.Meta, options)
 }
 
 var Cli struct {
-	Edit editCmd `cmd help:"Edits a remote blob and optionally stores it elsewhere."`
-	View viewCmd `cmd help:"Views a remote blob."`
+	Edit     editCmd     `cmd:"" help:"Edits a remote blob and optionally stores it elsewhere."`
+	View     viewCmd     `cmd:"" help:"Views a remote blob."`
+	Exists   existsCmd   `cmd:"" help:"Exits with 0 if the blob exists, 2 if it doesn't, 1 if it couldn't be checked."`
+	SetMeta  setMetaCmd  `cmd:"" name:"set-meta" help:"Changes the metadata of a remote blob, leaving its content intact."`
+	Doctor   doctorCmd   `cmd:"" help:"Checks which storage backends are usable, e.g. reachable with credentials that resolve."`
+	Versions versionsCmd `cmd:"" help:"Lists the versions of an S3 object, to view or edit one with --version-id."`
 
 	// Competion
 	InstallCompletions kongplete.InstallCompletions `cmd:"" help:"install shell completions"`
 }
+
+// URIMapper parses locations with storage.ParseURI, so local paths looking like URLs stay local.
+type URIMapper struct{}
+
+func (URIMapper) Decode(ctx *kong.DecodeContext, target reflect.Value) error {
+	var raw string
+	if err := ctx.Scan.PopValueInto("uri", &raw); err != nil {
+		return err
+	}
+
+	uri, err := storage.ParseURI(raw)
+	if err != nil {
+		return err
+	}
+
+	if target.Kind() == reflect.Ptr {
+		target.Set(reflect.ValueOf(&uri))
+	} else {
+		target.Set(reflect.ValueOf(uri))
+	}
+	return nil
+}
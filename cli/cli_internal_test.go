@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"net/url"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetLineRange(t *testing.T) {
+	cases := []struct {
+		lineRange string
+		first     int
+		last      int
+		fails     bool
+	}{
+		{lineRange: "", first: 0, last: 0},
+		{lineRange: "10:20", first: 10, last: 20},
+		{lineRange: "10:10", first: 10, last: 10},
+		{lineRange: "10:", first: 10, last: 0},
+		{lineRange: "20:10", fails: true},
+		{lineRange: "0:10", fails: true},
+		{lineRange: ":10", fails: true},
+		{lineRange: "10", fails: true},
+		{lineRange: "a:b", fails: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.lineRange, func(t *testing.T) {
+			first, last, err := commonFlags{LineRange: tc.lineRange}.getLineRange()
+
+			if tc.fails {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.first, first)
+			assert.Equal(t, tc.last, last)
+		})
+	}
+}
+
+func TestExistsCheck(t *testing.T) {
+	dir := t.TempDir()
+	existing := path.Join(dir, "blob.txt")
+	os.WriteFile(existing, []byte("test"), 0600)
+
+	cases := []struct {
+		name     string
+		path     string
+		expected int
+		fails    bool
+	}{
+		{name: "existing", path: existing, expected: 0},
+		{name: "missing", path: path.Join(dir, "missing.txt"), expected: missingExitCode},
+		{name: "unchecked", path: path.Join(existing, "blob.txt"), fails: true}, // Under a file, not a folder
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			code, err := existsCmd{SourcePath: url.URL{Path: tc.path}}.check()
+			if tc.fails {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, code)
+		})
+	}
+}
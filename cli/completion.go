@@ -2,7 +2,6 @@ package cli
 
 import (
 	"fmt"
-	"net/url"
 	"os"
 	"techiecaro/remblob/storage"
 
@@ -29,17 +28,21 @@ func (p PathPredictor) matchFileLister(pattern string) []string {
 		return []string{}
 	}
 
-	prefixURL, err := url.Parse(pattern)
+	prefixURL, err := storage.ParseURI(pattern)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Can't parse %s\n", prefixURL)
+		fmt.Fprintf(os.Stderr, "Can't parse %s\n", pattern)
 		return []string{}
 	}
 
-	lister := storage.GetFileLister(*prefixURL)
-	matchesURL := lister(*prefixURL)
+	lister := storage.GetFileLister(prefixURL)
+	matchesURL := lister(prefixURL)
 	matches := make([]string, len(matchesURL))
 	for i, match := range matchesURL {
 		matches[i] = match.String()
+		if match.Scheme == "" {
+			// Local paths are suggested as typed, without URL escaping
+			matches[i] = match.Path
+		}
 	}
 
 	return matches
@@ -41,27 +41,27 @@ func TestPathPredictor(t *testing.T) {
 	}{
 		{
 			prefix:   "",
-			expected: []string{"./", "file://", "s3://"},
+			expected: []string{"./", "data:", "dav://", "davs://", "file://", "gdrive://", "local-s3://", "r2://", "s3://"},
 		},
 		{
 			prefix:   ".",
-			expected: []string{"./", "file://", "s3://", "./1.txt", "./2.txt", "./a"},
+			expected: []string{"./", "data:", "dav://", "davs://", "file://", "gdrive://", "local-s3://", "r2://", "s3://", "./1.txt", "./2.txt", "./a"},
 		},
 		{
 			prefix:   "a/",
-			expected: []string{"./", "file://", "s3://", "a/a1.txt"},
+			expected: []string{"./", "data:", "dav://", "davs://", "file://", "gdrive://", "local-s3://", "r2://", "s3://", "a/a1.txt"},
 		},
 		{
 			prefix:   "./a/",
-			expected: []string{"./", "file://", "s3://", "./a/a1.txt"},
+			expected: []string{"./", "data:", "dav://", "davs://", "file://", "gdrive://", "local-s3://", "r2://", "s3://", "./a/a1.txt"},
 		},
 		{
 			prefix:   "file://",
-			expected: []string{"./", "file://", "s3://", "file://1.txt", "file://2.txt", "file://a"},
+			expected: []string{"./", "data:", "dav://", "davs://", "file://", "gdrive://", "local-s3://", "r2://", "s3://", "file://1.txt", "file://2.txt", "file://a"},
 		},
 		{
 			prefix:   "file://a",
-			expected: []string{"./", "file://", "s3://", "file://a/a1.txt"},
+			expected: []string{"./", "data:", "dav://", "davs://", "file://", "gdrive://", "local-s3://", "r2://", "s3://", "file://a/a1.txt"},
 		},
 	}
 
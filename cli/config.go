@@ -0,0 +1,240 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"techiecaro/remblob/editor"
+	"techiecaro/remblob/storage"
+
+	"github.com/alecthomas/kong"
+	"gopkg.in/yaml.v3"
+)
+
+// projectConfigPath is the config file of the project remblob is run in
+const projectConfigPath = ".remblob.yaml"
+
+// commandKeys are the flags running shell commands. The project's config file, which comes with whatever is checked out,
+// can't set them, nor editors.
+var commandKeys = []string{"in-cmd", "out-cmd", "filter-cmd"}
+
+// GetConfigPaths returns the config files in the order of their precedence: the project's one overrides
+// the user's one, in $XDG_CONFIG_HOME or ~/.config. CLI flags and environment variables override both.
+func GetConfigPaths() []string {
+	return append(getUserConfigPaths(), projectConfigPath)
+}
+
+// getUserConfigPaths returns the config file of the user, none when there's no home directory
+func getUserConfigPaths() []string {
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return []string{}
+		}
+		configDir = filepath.Join(home, ".config")
+	}
+
+	return []string{filepath.Join(configDir, "remblob", "config.yaml")}
+}
+
+// Configuration resolves flags from the config files of GetConfigPaths, leaving out commands set by the project's one.
+func Configuration() kong.Option {
+	return kong.OptionFunc(func(k *kong.Kong) error {
+		if err := kong.Configuration(YAMLConfig, getUserConfigPaths()...).Apply(k); err != nil {
+			return err
+		}
+		return kong.Configuration(projectYAMLConfig, projectConfigPath).Apply(k)
+	})
+}
+
+// YAMLConfig resolves flags from a YAML file, keyed by the flag names, e.g. "s3-provider: b2".
+func YAMLConfig(r io.Reader) (kong.Resolver, error) {
+	values, err := readYAMLValues(r)
+	if err != nil {
+		return nil, err
+	}
+	return yamlResolver(values), nil
+}
+
+// projectYAMLConfig resolves flags from the project's config file, ignoring commands with a warning
+func projectYAMLConfig(r io.Reader) (kong.Resolver, error) {
+	values, err := readYAMLValues(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range getCommandKeys(values) {
+		fmt.Fprintf(os.Stderr, "Ignoring %s in %s, commands are only read from the user's config file\n", key, projectConfigPath)
+	}
+	for _, key := range commandKeys {
+		delete(values, key)
+		delete(values, strings.ReplaceAll(key, "-", "_"))
+	}
+	return yamlResolver(values), nil
+}
+
+// getCommandKeys lists the keys of the config setting commands, editors included
+func getCommandKeys(values map[string]interface{}) []string {
+	keys := []string{}
+	for _, key := range commandKeys {
+		if _, ok := values[key]; ok {
+			keys = append(keys, key)
+		} else if _, ok := values[strings.ReplaceAll(key, "-", "_")]; ok {
+			keys = append(keys, key)
+		}
+	}
+	if _, ok := values["editors"]; ok {
+		keys = append(keys, "editors")
+	}
+	schemes, _ := values["schemes"].(map[string]interface{})
+	names := []string{}
+	for scheme := range schemes {
+		names = append(names, scheme)
+	}
+	sort.Strings(names)
+	for _, scheme := range names {
+		if config, ok := schemes[scheme].(map[string]interface{}); ok && config["editor"] != nil {
+			keys = append(keys, "schemes."+scheme+".editor")
+		}
+	}
+	return keys
+}
+
+func readYAMLValues(r io.Reader) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	if err := yaml.NewDecoder(r).Decode(&values); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return values, nil
+}
+
+func yamlResolver(values map[string]interface{}) kong.Resolver {
+	var resolver kong.ResolverFunc = func(context *kong.Context, parent *kong.Path, flag *kong.Flag) (interface{}, error) {
+		// Kong applies environment variables as defaults, they still take precedence
+		if flag.Tag.Env != "" && os.Getenv(flag.Tag.Env) != "" {
+			return nil, nil
+		}
+
+		if raw, ok := values[flag.Name]; ok {
+			return raw, nil
+		}
+		return values[strings.ReplaceAll(flag.Name, "-", "_")], nil
+	}
+	return resolver
+}
+
+// schemeConfig holds the defaults for a single scheme, under "schemes" in the config files, e.g.
+//
+//	schemes:
+//	  s3:
+//	    region: eu-west-1
+//	    storage-class: INTELLIGENT_TIERING
+//	  file:
+//	    editor: code --wait
+type schemeConfig struct {
+	storage.SchemeDefaults `yaml:",inline"`
+	Editor                 string `yaml:"editor"`
+}
+
+// loadSchemeConfigs reads the scheme defaults of the config files, later files overriding earlier ones field by field.
+// Invalid files are skipped, kong reports them when resolving the flags.
+func loadSchemeConfigs(paths []string) map[string]schemeConfig {
+	schemes := map[string]schemeConfig{}
+	for _, path := range paths {
+		var config struct {
+			Schemes map[string]schemeConfig `yaml:"schemes"`
+		}
+		if err := readConfigFile(path, &config); err != nil {
+			continue
+		}
+
+		for scheme, override := range config.Schemes {
+			merged := schemes[scheme]
+			if override.Region != "" {
+				merged.Region = override.Region
+			}
+			if override.StorageClass != "" {
+				merged.StorageClass = override.StorageClass
+			}
+			if override.Editor != "" {
+				merged.Editor = override.Editor
+			}
+			schemes[scheme] = merged
+		}
+	}
+	return schemes
+}
+
+// loadExtensionEditors reads the editor commands by file extension, under "editors" in the config files, e.g.
+//
+//	editors:
+//	  .csv: vd
+//
+// Extensions are lowercased and dotted, later files override earlier ones extension by extension.
+func loadExtensionEditors(paths []string) map[string]string {
+	editors := map[string]string{}
+	for _, path := range paths {
+		var config struct {
+			Editors map[string]string `yaml:"editors"`
+		}
+		if err := readConfigFile(path, &config); err != nil {
+			continue
+		}
+
+		for extension, command := range config.Editors {
+			extension = "." + strings.TrimPrefix(strings.ToLower(extension), ".")
+			editors[extension] = command
+		}
+	}
+	return editors
+}
+
+// readConfigFile decodes the config file into config. Invalid files fail, kong reports them when resolving the flags.
+func readConfigFile(path string, config interface{}) error {
+	file, err := os.Open(kong.ExpandPath(path))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = yaml.NewDecoder(file).Decode(config)
+	if err == io.EOF {
+		return nil // Empty file
+	}
+	return err
+}
+
+// getSchemeDefaults are the storage defaults by scheme from the config files
+func getSchemeDefaults() map[string]storage.SchemeDefaults {
+	defaults := map[string]storage.SchemeDefaults{}
+	for scheme, config := range loadSchemeConfigs(GetConfigPaths()) {
+		defaults[scheme] = config.SchemeDefaults
+	}
+	return defaults
+}
+
+// getSchemeEditor is the editor command configured by the user for the scheme of the uri, local files use "file"
+func getSchemeEditor(uri url.URL) string {
+	scheme := uri.Scheme
+	if scheme == "" {
+		scheme = "file"
+	}
+	return loadSchemeConfigs(getUserConfigPaths())[scheme].Editor
+}
+
+// getEnvEditor is the editor for the uri: the one configured for the extension of the file edited, or else $EDITOR,
+// or else the one configured for its scheme
+func getEnvEditor(uri url.URL, timeout time.Duration) editor.EnvEditor {
+	return editor.EnvEditor{
+		Timeout:    timeout,
+		Command:    getSchemeEditor(uri),
+		Extensions: loadExtensionEditors(getUserConfigPaths()),
+	}
+}
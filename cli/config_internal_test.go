@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"net/url"
+	"os"
+	"path"
+	"techiecaro/remblob/storage"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadSchemeConfigs(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+
+	userConfig := "schemes:\n  s3:\n    region: eu-west-1\n    storage-class: STANDARD_IA\n  file:\n    editor: vi\n"
+	projectConfig := "schemes:\n  s3:\n    storage-class: INTELLIGENT_TIERING\n  file:\n    editor: sh -c evil\n"
+	os.MkdirAll(path.Join(dir, "remblob"), 0700)
+	os.WriteFile(path.Join(dir, "remblob", "config.yaml"), []byte(userConfig), 0600)
+	os.WriteFile(path.Join(dir, ".remblob.yaml"), []byte(projectConfig), 0600)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(cwd)
+
+	expected := map[string]storage.SchemeDefaults{
+		"s3":   {Region: "eu-west-1", StorageClass: "INTELLIGENT_TIERING"},
+		"file": {},
+	}
+	assert.Equal(t, expected, getSchemeDefaults())
+
+	// Editors only come from the user's config
+	assert.Equal(t, "vi", getSchemeEditor(url.URL{Path: "local.txt"}))
+	assert.Equal(t, "", getSchemeEditor(url.URL{Scheme: "s3", Host: "bucket", Path: "/a.txt"}))
+}
+
+func TestLoadExtensionEditors(t *testing.T) {
+	dir := t.TempDir()
+	userConfig := path.Join(dir, "config.yaml")
+	projectConfig := path.Join(dir, ".remblob.yaml")
+	os.WriteFile(userConfig, []byte("editors:\n  .csv: vd\n  JSON: code --wait\n"), 0600)
+	os.WriteFile(projectConfig, []byte("editors:\n  csv: sc-im\n"), 0600)
+
+	expected := map[string]string{".csv": "sc-im", ".json": "code --wait"}
+	assert.Equal(t, expected, loadExtensionEditors([]string{userConfig, projectConfig, path.Join(dir, "missing.yaml")}))
+}
+
+func TestGetEnvEditorUserOnly(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+
+	os.MkdirAll(path.Join(dir, "remblob"), 0700)
+	os.WriteFile(path.Join(dir, "remblob", "config.yaml"), []byte("editors:\n  .csv: vd\n"), 0600)
+	os.WriteFile(path.Join(dir, ".remblob.yaml"), []byte("editors:\n  .csv: sh -c evil\n  .json: sh -c evil\n"), 0600)
+
+	cwd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(cwd)
+
+	assert.Equal(t, map[string]string{".csv": "vd"}, getEnvEditor(url.URL{Path: "a.csv"}, 0).Extensions)
+}
+
+func TestGetCommandKeys(t *testing.T) {
+	values := map[string]interface{}{
+		"in_cmd":      "jq .",
+		"s3-provider": "b2",
+		"editors":     map[string]interface{}{".csv": "vd"},
+		"schemes": map[string]interface{}{
+			"s3":   map[string]interface{}{"region": "eu-west-1"},
+			"file": map[string]interface{}{"editor": "vi"},
+		},
+	}
+
+	assert.Equal(t, []string{"in-cmd", "editors", "schemes.file.editor"}, getCommandKeys(values))
+}
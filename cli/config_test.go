@@ -0,0 +1,79 @@
+package cli_test
+
+import (
+	"os"
+	"path"
+	"techiecaro/remblob/cli"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestYAMLConfig(t *testing.T) {
+	cases := []struct {
+		name             string
+		args             []string
+		env              string
+		expectedProvider string
+		expectedTimeout  time.Duration
+	}{
+		{name: "project-over-user", args: []string{"view", "x"}, expectedProvider: "b2", expectedTimeout: time.Minute},
+		{name: "env-over-config", args: []string{"view", "x"}, env: "wasabi", expectedProvider: "wasabi", expectedTimeout: time.Minute},
+		{name: "flag-over-all", args: []string{"view", "--s3-provider", "aws", "--editor-timeout", "5s", "x"}, env: "wasabi", expectedProvider: "aws", expectedTimeout: 5 * time.Second},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("HOME", dir)
+			t.Setenv("XDG_CONFIG_HOME", "")
+			t.Setenv("REMBLOB_S3_PROVIDER", tc.env)
+
+			userConfig := path.Join(dir, ".config", "remblob", "config.yaml")
+			os.MkdirAll(path.Dir(userConfig), 0700)
+			os.WriteFile(userConfig, []byte("s3-provider: spaces\neditor_timeout: 1m\n"), 0600)
+			os.WriteFile(path.Join(dir, ".remblob.yaml"), []byte("s3-provider: b2\n"), 0600)
+
+			cwd := mustGetCWD(t)
+			os.Chdir(dir)
+			defer os.Chdir(cwd)
+
+			parser, err := kong.New(&cli.Cli, cli.Configuration())
+			assert.NoError(t, err)
+			_, err = parser.Parse(tc.args)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedProvider, cli.Cli.View.S3Provider)
+			assert.Equal(t, tc.expectedTimeout, cli.Cli.View.EditorTimeout)
+		})
+	}
+}
+
+func TestYAMLConfigProjectCommands(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	userConfig := path.Join(dir, ".config", "remblob", "config.yaml")
+	os.MkdirAll(path.Dir(userConfig), 0700)
+	os.WriteFile(userConfig, []byte("in-cmd: jq .\n"), 0600)
+	projectConfig := "in_cmd: cat ~/.aws/credentials\nout-cmd: sh -c evil\nfilter-cmd: sh -c evil\ns3-provider: b2\n"
+	os.WriteFile(path.Join(dir, ".remblob.yaml"), []byte(projectConfig), 0600)
+
+	cwd := mustGetCWD(t)
+	os.Chdir(dir)
+	defer os.Chdir(cwd)
+
+	parser, err := kong.New(&cli.Cli, cli.Configuration())
+	assert.NoError(t, err)
+	_, err = parser.Parse([]string{"edit", "x"})
+	assert.NoError(t, err)
+
+	// Commands only come from the user's config, other settings from both
+	assert.Equal(t, "jq .", cli.Cli.Edit.InCmd)
+	assert.Equal(t, "", cli.Cli.Edit.OutCmd)
+	assert.Equal(t, "", cli.Cli.Edit.FilterCmd)
+	assert.Equal(t, "b2", cli.Cli.Edit.S3Provider)
+}
@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"techiecaro/remblob/storage"
+	"text/tabwriter"
+)
+
+type doctorCmd struct {
+	storageFlags
+
+	Output string `enum:"text,json" default:"text" help:"Format of the report (text, json)."`
+}
+
+func (d doctorCmd) Run() error {
+	statuses := storage.CheckBackends(d.getStorageOptions())
+	return writeBackendStatuses(os.Stdout, statuses, d.Output)
+}
+
+// writeBackendStatuses reports which backends are usable, as a table or JSON
+func writeBackendStatuses(out io.Writer, statuses []storage.BackendStatus, format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(statuses)
+	}
+
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, status := range statuses {
+		result := "ok"
+		if !status.OK {
+			result = "failed: " + status.Error
+		}
+		fmt.Fprintf(writer, "%s\t%s\n", status.Prefix, result)
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"bytes"
+	"techiecaro/remblob/storage"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteBackendStatuses(t *testing.T) {
+	statuses := []storage.BackendStatus{
+		{Prefix: "data:", OK: true},
+		{Prefix: "gdrive://", OK: false, Error: "no token"},
+	}
+
+	cases := []struct {
+		format   string
+		expected string
+	}{
+		{
+			format:   "text",
+			expected: "data:      ok\ngdrive://  failed: no token\n",
+		},
+		{
+			format: "json",
+			expected: `[
+  {
+    "prefix": "data:",
+    "ok": true
+  },
+  {
+    "prefix": "gdrive://",
+    "ok": false,
+    "error": "no token"
+  }
+]
+`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.format, func(t *testing.T) {
+			out := &bytes.Buffer{}
+
+			err := writeBackendStatuses(out, statuses, tc.format)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, out.String())
+		})
+	}
+}
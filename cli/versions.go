@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"techiecaro/remblob/core"
+	"techiecaro/remblob/storage"
+	"text/tabwriter"
+	"time"
+)
+
+type versionsCmd struct {
+	storageFlags
+
+	Output string `enum:"text,json" default:"text" help:"Format of the list (text, json)."`
+
+	SourcePath url.URL `arg:"" name:"source_path" help:"Location of the blob to list the versions of." predictor:"path"`
+}
+
+func (v versionsCmd) Run() error {
+	versions, err := core.ListVersions(v.SourcePath, core.Options{Storage: v.getStorageOptions()})
+	if err != nil {
+		return err
+	}
+	return writeVersions(os.Stdout, versions, v.Output)
+}
+
+// writeVersions lists the versions with their ids, to pass to --version-id, as a table or JSON
+func writeVersions(out io.Writer, versions []storage.ObjectVersion, format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(versions)
+	}
+
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, version := range versions {
+		state := ""
+		switch {
+		case version.DeleteMarker && version.Latest:
+			state = "latest, deleted"
+		case version.DeleteMarker:
+			state = "deleted"
+		case version.Latest:
+			state = "latest"
+		}
+		modified := version.LastModified.UTC().Format(time.RFC3339)
+		fmt.Fprintf(writer, "%s\t%s\t%d\t%s\n", version.ID, modified, version.Size, state)
+	}
+	return writer.Flush()
+}
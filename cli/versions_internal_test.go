@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"bytes"
+	"techiecaro/remblob/storage"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteVersions(t *testing.T) {
+	day := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	versions := []storage.ObjectVersion{
+		{ID: "v3", LastModified: day.Add(2 * time.Hour), Latest: true, DeleteMarker: true},
+		{ID: "v2", LastModified: day.Add(time.Hour), Size: 6},
+	}
+
+	cases := []struct {
+		format   string
+		expected string
+	}{
+		{
+			format: "text",
+			expected: "v3  2021-09-01T02:00:00Z  0  latest, deleted\n" +
+				"v2  2021-09-01T01:00:00Z  6  \n",
+		},
+		{
+			format: "json",
+			expected: `[
+  {
+    "id": "v3",
+    "last_modified": "2021-09-01T02:00:00Z",
+    "size": 0,
+    "latest": true,
+    "delete_marker": true
+  },
+  {
+    "id": "v2",
+    "last_modified": "2021-09-01T01:00:00Z",
+    "size": 6,
+    "latest": false,
+    "delete_marker": false
+  }
+]
+`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.format, func(t *testing.T) {
+			out := &bytes.Buffer{}
+
+			err := writeVersions(out, versions, tc.format)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, out.String())
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+// binarySniffSize is how much of the file is looked at to tell if it's binary
+const binarySniffSize = 8000
+
+// ErrBinary is returned when a binary file would be opened in a text editor
+var ErrBinary = errors.New("File looks binary, editing it as text could corrupt it. " +
+	"Convert it with --in-cmd/--out-cmd, or use --force-binary")
+
+// checkText refuses files that look binary: having NUL bytes or invalid UTF-8 at their start
+func checkText(file *os.File) error {
+	defer file.Seek(0, io.SeekStart)
+
+	file.Seek(0, io.SeekStart)
+	head := make([]byte, binarySniffSize)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	head = head[:n]
+
+	if bytes.IndexByte(head, 0) >= 0 {
+		return ErrBinary
+	}
+
+	// A character may be cut at the end of the sniffed part
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size == 1 {
+			if n == binarySniffSize && !utf8.FullRune(head) {
+				break
+			}
+			return ErrBinary
+		}
+		head = head[size:]
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"techiecaro/remblob/storage"
+)
+
+// cachedSource serves the source from the cache directory when it has a copy of the source's current version.
+// Otherwise the source is read as usual, while being stored in the cache under the version actually read.
+func cachedSource(source url.URL, src storage.FileStorage, options Options) (io.ReadCloser, error) {
+	versioned, ok := src.(storage.VersionCapable)
+	// A cached copy can't tell if it was modified since a time
+	if options.CacheDir == "" || !ok || !options.Storage.IfModifiedSince.IsZero() {
+		return src, nil
+	}
+
+	version, err := versioned.GetVersion()
+	if err != nil {
+		return nil, err
+	}
+	if cached, err := os.Open(getCachePath(options.CacheDir, source, version)); err == nil {
+		src.Close()
+		return cached, nil
+	}
+
+	if err := os.MkdirAll(options.CacheDir, 0700); err != nil {
+		return nil, err
+	}
+	partial, err := ioutil.TempFile(options.CacheDir, "partial-*")
+	if err != nil {
+		return nil, err
+	}
+	return &cachingReadCloser{src: src, versioned: versioned, partial: partial, source: source, cacheDir: options.CacheDir}, nil
+}
+
+// getCachePath is where the copy of the version of the source is cached
+func getCachePath(cacheDir string, source url.URL, version string) string {
+	key := sha256.Sum256([]byte(source.String() + "\n" + version))
+	return filepath.Join(cacheDir, hex.EncodeToString(key[:]))
+}
+
+// cachingReadCloser copies what is read into the cache. The copy is kept only when the whole source was read,
+// under the version the source told when reading, as it may have changed since it was looked up.
+type cachingReadCloser struct {
+	src       storage.FileStorage
+	versioned storage.VersionCapable
+	partial   *os.File
+	source    url.URL
+	cacheDir  string
+	count     int64
+	complete  bool
+}
+
+func (c *cachingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.src.Read(p)
+	if n > 0 {
+		if _, writeErr := c.partial.Write(p[:n]); writeErr != nil {
+			return n, writeErr
+		}
+		c.count += int64(n)
+	}
+	if err == io.EOF {
+		c.complete = true
+	}
+	return n, err
+}
+
+func (c *cachingReadCloser) Close() error {
+	err := c.src.Close()
+	c.partial.Close()
+
+	version, known := c.versioned.GetReadVersion()
+	if err == nil && c.complete && known && checkContentLength(c.src, c.count) == nil {
+		if os.Rename(c.partial.Name(), getCachePath(c.cacheDir, c.source, version)) == nil {
+			return nil
+		}
+	}
+	os.Remove(c.partial.Name())
+	return err
+}
+
+// GetContentLength passes the size of the source through, for checking partial reads.
+func (c *cachingReadCloser) GetContentLength() (int64, bool) {
+	if capable, ok := c.src.(storage.ContentLengthCapable); ok {
+		return capable.GetContentLength()
+	}
+	return 0, false
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// racingStorage is changed between looking its version up and reading it
+type racingStorage struct {
+	io.Reader
+	version     string
+	readVersion string
+}
+
+func (r *racingStorage) Write(p []byte) (int, error)    { return 0, io.ErrClosedPipe }
+func (r *racingStorage) Close() error                   { return nil }
+func (r *racingStorage) GetVersion() (string, error)    { return r.version, nil }
+func (r *racingStorage) GetReadVersion() (string, bool) { return r.readVersion, true }
+
+func TestCachedSourceKeyedByRead(t *testing.T) {
+	cacheDir := t.TempDir()
+	source := url.URL{Scheme: "s3", Host: "bucket", Path: "/a.txt"}
+	options := Options{CacheDir: cacheDir}
+
+	src := &racingStorage{Reader: strings.NewReader("new"), version: "old", readVersion: "new"}
+	cached, err := cachedSource(source, src, options)
+	assert.NoError(t, err)
+	body, err := io.ReadAll(cached)
+	assert.NoError(t, err)
+	assert.NoError(t, cached.Close())
+	assert.Equal(t, "new", string(body))
+
+	// Cached as the version read, not the one looked up
+	assert.NoFileExists(t, getCachePath(cacheDir, source, "old"))
+	assert.FileExists(t, getCachePath(cacheDir, source, "new"))
+
+	// So the old version doesn't get served the new content
+	src = &racingStorage{Reader: strings.NewReader("old"), version: "old", readVersion: "old"}
+	cached, err = cachedSource(source, src, options)
+	assert.NoError(t, err)
+	body, err = io.ReadAll(cached)
+	assert.NoError(t, err)
+	assert.NoError(t, cached.Close())
+	assert.Equal(t, "old", string(body))
+
+	entries, err := os.ReadDir(cacheDir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
@@ -4,49 +4,242 @@ import (
 	"fmt"
 	"io"
 	"net/url"
+	"time"
 
 	"techiecaro/remblob/editor"
 	"techiecaro/remblob/shovel"
 	"techiecaro/remblob/storage"
 )
 
-func Edit(source url.URL, destination url.URL, localEditor editor.Editor) error {
-	src, err := storage.GetFileStorage(source)
+// Options tweak how blobs are transferred and edited.
+type Options struct {
+	Storage    storage.Options
+	Encoding   string // Text encoding of the source, see shovel.EncodingShovel
+	InCommand  string // Converts the source before editing, see shovel.CommandShovel
+	OutCommand string // Converts the edited file before writing
+	Stamp      bool   // Records who edited the file and when in its metadata
+	Comment    string // Records why the file was edited, implies Stamp
+
+	ContentDisposition string // Sets the Content-Disposition header of the destination, where supported
+
+	TrailingNewline  string // One of the Newline constants
+	IgnoreWhitespace bool   // Whitespace only changes don't count as changes
+
+	CSVStrict    bool // Requires CSV records to have the same number of fields
+	CSVDelimiter rune // Delimiter of CSV fields. Zero detects it
+	CSVPlain     bool // Edits CSV as plain text, neither normalized nor validated
+
+	ForceBinary bool // Allows editing files that look binary
+
+	KeepCompression bool // Destinations without a compression extension keep the source's compression
+	Lenient         bool // Reads .gz sources which aren't Gzip compressed as plain text
+	LenientGzip     bool // Reads the start of truncated .gz sources instead of failing, meant for viewing
+
+	SourceCompression      string // One of "none", "gzip" or "snappy" overrides the source's extension. Empty detects it
+	DestinationCompression string // Same for the destination, winning over KeepCompression
+
+	CacheDir string // Keeps copies of viewed sources, reused while their version doesn't change. Empty disables it
+
+	MaxRowsInteractive int // CSV/TSV with more lines aren't opened in the editor. Zero means no limit
+
+	Overwrite string // One of the Overwrite constants. Empty always overwrites
+
+	FirstLine int // Only the lines from FirstLine, counted from 1, to LastLine are edited. Zero edits all
+	LastLine  int // Zero means up to the last line
+}
+
+func Edit(source url.URL, destination url.URL, localEditor editor.Editor, options Options) error {
+	// Editing in place writes back to the very object read
+	sameFile := source == destination
+	source, err := storage.ResolveURI(source, options.Storage)
 	if err != nil {
 		return err
 	}
-	dst, err := storage.GetFileStorage(destination)
+	if sameFile {
+		destination = source
+	} else if destination, err = storage.ResolveURI(destination, options.Storage); err != nil {
+		return err
+	}
+
+	src, err := storage.GetFileStorage(source, options.Storage)
+	if err != nil {
+		return err
+	}
+	// Writing makes a new version, even when an older one was read.
+	// The destination is compared against whenever it was modified, only the source is checked for that
+	destinationOptions := options
+	destinationOptions.Storage.VersionID = ""
+	destinationOptions.Storage.IfModifiedSince = time.Time{}
+	dst, err := storage.GetFileStorage(destination, destinationOptions.Storage)
+	if err != nil {
+		return err
+	}
+	if err := checkWritable(dst); err != nil {
+		return err
+	}
+	if err := checkDestination(destination, dst, options.Overwrite); err != nil {
+		return err
+	}
+	currentHash, err := getCurrentHash(destination, dst, destinationOptions)
 	if err != nil {
 		return err
 	}
 
-	shovel := shovel.MultiShovel{
-		SourceCompressed:      isCompressed(source),
-		DestinationCompressed: isCompressed(destination),
+	shovel := getShovel(source, destination, options)
+
+	baseName := getTempBaseName(source, src)
+
+	scope := getHeaderScope(source, destination, sameFile, options)
+
+	return remoteEdit(baseName, src, dst, currentHash, shovel, scope, localEditor, options)
+}
+
+func View(source url.URL, localEditor editor.Editor, options Options) error {
+	source, err := storage.ResolveURI(source, options.Storage)
+	if err != nil {
+		return err
+	}
+	fileStorage, err := storage.GetFileStorage(source, options.Storage)
+	if err != nil {
+		return err
+	}
+	src, err := cachedSource(source, fileStorage, options)
+	if err != nil {
+		return err
 	}
 
-	baseName := getBaseName(source)
+	shovel := getShovel(source, source, options) // Destination not in use
+
+	baseName := getTempBaseName(source, fileStorage)
 
-	return remoteEdit(baseName, src, dst, shovel, localEditor)
+	return remoteView(baseName, src, shovel, localEditor, options)
 }
 
-func View(source url.URL, localEditor editor.Editor) error {
-	src, err := storage.GetFileStorage(source)
+// Print writes the content of the source to out, as it would be presented to the editor.
+func Print(source url.URL, out io.Writer, options Options) error {
+	source, err := storage.ResolveURI(source, options.Storage)
+	if err != nil {
+		return err
+	}
+	fileStorage, err := storage.GetFileStorage(source, options.Storage)
+	if err != nil {
+		return err
+	}
+	src, err := cachedSource(source, fileStorage, options)
 	if err != nil {
 		return err
 	}
 
-	shovel := shovel.MultiShovel{
-		SourceCompressed:      isCompressed(source),
-		DestinationCompressed: false, // Not in use
+	shovel := getShovel(source, source, options) // Destination not in use
+
+	return shovel.CopyIn(nopWriteCloser{out}, src)
+}
+
+func getShovel(source url.URL, destination url.URL, options Options) shovel.Shovel {
+	sourceCompression := getSourceCompression(source, options.SourceCompression)
+	var fileShovel shovel.Shovel = &shovel.MultiShovel{
+		SourceCompression:      sourceCompression,
+		DestinationCompression: getDestinationCompression(sourceCompression, destination, options.DestinationCompression, options.KeepCompression),
+		Lenient:                options.Lenient,
+		Truncated:              options.LenientGzip,
+	}
+	fileShovel = shovel.CommandShovel{
+		Shovel:     fileShovel,
+		InCommand:  options.InCommand,
+		OutCommand: options.OutCommand,
+	}
+	fileShovel = &shovel.EncodingShovel{
+		Shovel:   fileShovel,
+		Encoding: options.Encoding,
+	}
+	if isDotenv(destination) {
+		fileShovel = shovel.DotenvShovel{Shovel: fileShovel}
+	}
+	if isCSV(source) && !options.CSVPlain {
+		fileShovel = &shovel.CSVShovel{
+			Shovel:        fileShovel,
+			Strict:        options.CSVStrict,
+			Comma:         options.CSVDelimiter,
+			FallbackComma: getFallbackDelimiter(source),
+		}
+	}
+	if options.FirstLine > 0 {
+		fileShovel = &shovel.LinesShovel{
+			Shovel: fileShovel,
+			First:  options.FirstLine,
+			Last:   options.LastLine,
+		}
+	}
+	return fileShovel
+}
+
+// nopWriteCloser lets a plain writer, e.g. stdout, be used as a shovel destination.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// Exists checks if the source exists, without downloading it.
+func Exists(source url.URL, options Options) (bool, error) {
+	source, err := storage.ResolveURI(source, options.Storage)
+	if err != nil {
+		return false, err
+	}
+	src, err := storage.GetFileStorage(source, options.Storage)
+	if err != nil {
+		return false, err
+	}
+
+	checker, ok := src.(storage.ExistenceChecker)
+	if !ok {
+		return false, fmt.Errorf("Can not check existence of this uri: %#v", source.String())
+	}
+
+	return checker.Exists()
+}
+
+// ListVersions lists the versions kept of the source, the most recent first.
+func ListVersions(source url.URL, options Options) ([]storage.ObjectVersion, error) {
+	source, err := storage.ResolveURI(source, options.Storage)
+	if err != nil {
+		return nil, err
+	}
+	src, err := storage.GetFileStorage(source, options.Storage)
+	if err != nil {
+		return nil, err
 	}
 
-	baseName := getBaseName(source)
+	lister, ok := src.(storage.VersionLister)
+	if !ok {
+		return nil, fmt.Errorf("Can not list versions of this uri: %#v", source.String())
+	}
+
+	return lister.ListVersions()
+}
+
+// UpdateMetadata changes the metadata of the source, without transferring its content.
+func UpdateMetadata(source url.URL, contentType string, metadata map[string]string, options Options) error {
+	source, err := storage.ResolveURI(source, options.Storage)
+	if err != nil {
+		return err
+	}
+	src, err := storage.GetFileStorage(source, options.Storage)
+	if err != nil {
+		return err
+	}
+
+	updater, ok := src.(storage.MetadataUpdater)
+	if !ok {
+		return fmt.Errorf("Can not update metadata of this uri: %#v", source.String())
+	}
 
-	return remoteView(baseName, src, shovel, localEditor)
+	return updater.UpdateMetadata(contentType, metadata)
 }
 
-func remoteEdit(baseName string, src io.ReadCloser, dst io.WriteCloser, shovel shovel.Shovel, localEditor editor.Editor) error {
+func remoteEdit(baseName string, src io.ReadCloser, dst io.WriteCloser, currentHash []byte, shovel shovel.Shovel, scope headerScope, localEditor editor.Editor, options Options) error {
 	// Create file with a nice name, inside temp folder. Close to remove it
 	tmp, err := newNamedTempFile(baseName)
 	if err != nil {
@@ -55,12 +248,28 @@ func remoteEdit(baseName string, src io.ReadCloser, dst io.WriteCloser, shovel s
 	defer tmp.Close()
 
 	// Copy to local file, ready for the editor
-	if err := shovel.CopyIn(tmp.file, src); err != nil {
+	counter := &countingReadCloser{ReadCloser: src}
+	if err := shovel.CopyIn(tmp.file, counter); err != nil {
+		return err
+	}
+	if err := checkContentLength(src, counter.count); err != nil {
+		return err
+	}
+
+	// Binary content would get corrupted by a text editor
+	if !options.ForceBinary {
+		if err := checkText(tmp.file); err != nil {
+			return err
+		}
+	}
+
+	// Huge tables aren't for editing by hand
+	if err := checkRowCount(baseName, tmp.file, options.MaxRowsInteractive); err != nil {
 		return err
 	}
 
 	// User editing the file
-	changes, err := localEdit(tmp.file, localEditor)
+	changes, err := localEdit(tmp.file, localEditor, options)
 	if err != nil {
 		return err
 	}
@@ -71,14 +280,16 @@ func remoteEdit(baseName string, src io.ReadCloser, dst io.WriteCloser, shovel s
 	}
 
 	// Write to final destination
-	if err := shovel.CopyOut(dst, tmp.file); err != nil {
+	transferMetadata(src, dst, options)
+	transferHeaders(src, dst, scope, options)
+	if err := copyOutIfDifferent(dst, currentHash, tmp.file, shovel); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func remoteView(baseName string, src io.ReadCloser, shovel shovel.Shovel, localEditor editor.Editor) error {
+func remoteView(baseName string, src io.ReadCloser, shovel shovel.Shovel, localEditor editor.Editor, options Options) error {
 	// Create file with a nice name, inside temp folder. Close to remove it
 	tmp, err := newNamedTempFile(baseName)
 	if err != nil {
@@ -87,12 +298,21 @@ func remoteView(baseName string, src io.ReadCloser, shovel shovel.Shovel, localE
 	defer tmp.Close()
 
 	// Copy to local file, ready for the editor
-	if err := shovel.CopyIn(tmp.file, src); err != nil {
+	counter := &countingReadCloser{ReadCloser: src}
+	if err := shovel.CopyIn(tmp.file, counter); err != nil {
+		return err
+	}
+	if err := checkContentLength(src, counter.count); err != nil {
+		return err
+	}
+
+	// Huge tables aren't for editing by hand
+	if err := checkRowCount(baseName, tmp.file, options.MaxRowsInteractive); err != nil {
 		return err
 	}
 
 	// User editing the file
-	changes, err := localEdit(tmp.file, localEditor)
+	changes, err := localEdit(tmp.file, localEditor, options)
 	if err != nil {
 		return err
 	}
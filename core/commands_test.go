@@ -8,9 +8,13 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"strings"
 	"techiecaro/remblob/core"
+	"techiecaro/remblob/storage"
 	"testing"
+	"time"
 
+	"github.com/klauspost/compress/snappy"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -41,6 +45,20 @@ func readFileGzip(t *testing.T, filename string) string {
 	return string(body)
 }
 
+func readFileSnappy(t *testing.T, filename string) string {
+	reader, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	body, err := io.ReadAll(snappy.NewReader(reader))
+	if err != nil {
+		t.Fatal(err, body)
+	}
+	return string(body)
+}
+
 func writeFile(t *testing.T, filename string, data string) {
 	err := os.WriteFile(filename, []byte(data), 0700)
 	if err != nil {
@@ -60,6 +78,18 @@ func writeFileGzip(t *testing.T, filename string, data string) {
 	}
 }
 
+func writeFileSnappy(t *testing.T, filename string, data string) {
+	var b bytes.Buffer
+	w := snappy.NewBufferedWriter(&b)
+	w.Write([]byte(data))
+	w.Close()
+
+	err := os.WriteFile(filename, b.Bytes(), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func appendFile(t *testing.T, filename string, data string) {
 	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0700)
 	if err != nil {
@@ -126,7 +156,7 @@ func TestViewCommand(t *testing.T) {
 			src := createTestFile(t, rootDir, "input.txt", inputBody)
 			fakeEditor := &FakeEditor{t: t, appendWith: tc.change}
 
-			err := core.View(src, fakeEditor)
+			err := core.View(src, fakeEditor, core.Options{})
 
 			outputBody := readFile(t, src.String())
 
@@ -138,6 +168,94 @@ func TestViewCommand(t *testing.T) {
 	}
 }
 
+func TestPrintCommand(t *testing.T) {
+	inputBody := "test"
+	cases := []struct {
+		name  string
+		write func(t *testing.T, filename string, data string)
+	}{
+		{
+			name:  "input.txt",
+			write: writeFile,
+		},
+		{
+			name:  "input.gz",
+			write: writeFileGzip,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rootDir := t.TempDir()
+			src := testFileURL(t, rootDir, tc.name)
+			tc.write(t, src.String(), inputBody)
+
+			var out bytes.Buffer
+			err := core.Print(src, &out, core.Options{})
+
+			assert.NoError(t, err)
+			assert.Equal(t, inputBody, out.String())
+		})
+	}
+}
+
+func TestPrintCommandDataURI(t *testing.T) {
+	cases := []struct {
+		uri      string
+		expected string
+	}{
+		{uri: "data:text/plain,%22a%22,b", expected: "\"a\",b"},
+		{uri: "data:text/csv,%22a%22,b", expected: "a,b\n"},     // Goes through the CSV normalization
+		{uri: "data:text/csv,a%22b,%22c", expected: "a\"b,\"c"}, // Shown as is, as it doesn't parse
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.uri, func(t *testing.T) {
+			src, err := storage.ParseURI(tc.uri)
+			assert.NoError(t, err)
+
+			var out bytes.Buffer
+			err = core.Print(src, &out, core.Options{})
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, out.String())
+		})
+	}
+}
+
+func TestPrintCommandCache(t *testing.T) {
+	rootDir := t.TempDir()
+	cacheDir := path.Join(rootDir, "cache")
+	src := testFileURL(t, rootDir, "input.txt")
+	options := core.Options{CacheDir: cacheDir}
+
+	printSource := func() string {
+		var out bytes.Buffer
+		assert.NoError(t, core.Print(src, &out, options))
+		return out.String()
+	}
+
+	// Miss, the source gets cached
+	writeFile(t, src.String(), "test")
+	stat, err := os.Stat(src.String())
+	assert.NoError(t, err)
+	assert.Equal(t, "test", printSource())
+	cached, _ := os.ReadDir(cacheDir)
+	assert.Len(t, cached, 1)
+
+	// Hit, the version (size and modification time) didn't change
+	writeFile(t, src.String(), "abcd")
+	assert.NoError(t, os.Chtimes(src.String(), stat.ModTime(), stat.ModTime()))
+	assert.Equal(t, "test", printSource())
+
+	// Miss, the version changed
+	later := stat.ModTime().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(src.String(), later, later))
+	assert.Equal(t, "abcd", printSource())
+	cached, _ = os.ReadDir(cacheDir)
+	assert.Len(t, cached, 2)
+}
+
 func TestEditCommandSameFile(t *testing.T) {
 	inputBody := "test"
 	inputFile := "input.txt"
@@ -168,7 +286,7 @@ func TestEditCommandSameFile(t *testing.T) {
 
 			// Edit
 			fakeEditor := &FakeEditor{t: t, appendWith: tc.change}
-			err := core.Edit(src, dst, fakeEditor)
+			err := core.Edit(src, dst, fakeEditor, core.Options{})
 
 			// Read result of edited file
 			outputBody := readFile(t, dst.String())
@@ -194,7 +312,7 @@ func TestEditCommandNoChangeDifferentFiles(t *testing.T) {
 
 	// Edit
 	fakeEditor := &FakeEditor{t: t, appendWith: change}
-	err := core.Edit(src, dst, fakeEditor)
+	err := core.Edit(src, dst, fakeEditor, core.Options{})
 
 	// Read src file
 	srcBody := readFile(t, src.String())
@@ -220,7 +338,7 @@ func TestEditCommandChangeDifferentFiles(t *testing.T) {
 
 	// Edit
 	fakeEditor := &FakeEditor{t: t, appendWith: change}
-	err := core.Edit(src, dst, fakeEditor)
+	err := core.Edit(src, dst, fakeEditor, core.Options{})
 
 	// Read src and dst files
 	srcBody := readFile(t, src.String())
@@ -233,6 +351,87 @@ func TestEditCommandChangeDifferentFiles(t *testing.T) {
 	assert.Equal(t, inputBody, fakeEditor.body)
 }
 
+func TestEditCommandOverwrite(t *testing.T) {
+	cases := []struct {
+		name      string
+		overwrite string
+		existing  string // Empty when missing
+		written   bool
+		expected  error
+	}{
+		{name: "always missing", overwrite: core.OverwriteAlways, written: true},
+		{name: "always existing", overwrite: core.OverwriteAlways, existing: "old", written: true},
+		{name: "always same", overwrite: core.OverwriteAlways, existing: "test - change", written: true},
+		{name: "never missing", overwrite: core.OverwriteNever, written: true},
+		{name: "never existing", overwrite: core.OverwriteNever, existing: "old", expected: core.ErrDestinationExists},
+		{name: "if-different missing", overwrite: core.OverwriteIfDifferent, written: true},
+		{name: "if-different existing", overwrite: core.OverwriteIfDifferent, existing: "old", written: true},
+		{name: "if-different same", overwrite: core.OverwriteIfDifferent, existing: "test - change"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rootDir := t.TempDir()
+			src := createTestFile(t, rootDir, "input.txt", "test")
+			dst := testFileURL(t, rootDir, "output.txt")
+			past := time.Now().Add(-time.Hour).Truncate(time.Second)
+			if tc.existing != "" {
+				writeFile(t, dst.String(), tc.existing)
+				os.Chtimes(dst.String(), past, past)
+			}
+
+			fakeEditor := &FakeEditor{t: t, appendWith: " - change"}
+			err := core.Edit(src, dst, fakeEditor, core.Options{Overwrite: tc.overwrite})
+
+			if tc.expected != nil {
+				assert.ErrorIs(t, err, tc.expected)
+				assert.Equal(t, tc.existing, readFile(t, dst.String()))
+				assert.Equal(t, "", fakeEditor.body, "Editor not opened")
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "test - change", readFile(t, dst.String()))
+
+			stat, err := os.Stat(dst.String())
+			assert.NoError(t, err)
+			assert.Equal(t, tc.written, !stat.ModTime().Equal(past))
+		})
+	}
+}
+
+func TestEditCommandOverwriteIfModifiedSince(t *testing.T) {
+	rootDir := t.TempDir()
+	src := createTestFile(t, rootDir, "input.txt", "test")
+	dst := testFileURL(t, rootDir, "output.txt")
+	since := time.Now().Add(-time.Hour)
+	past := since.Add(-time.Hour)
+	writeFile(t, dst.String(), "old")
+	assert.NoError(t, os.Chtimes(dst.String(), past, past))
+
+	// The source is newer, the destination older, than the time given
+	fakeEditor := &FakeEditor{t: t, appendWith: " - change"}
+	options := core.Options{Overwrite: core.OverwriteIfDifferent, Storage: storage.Options{IfModifiedSince: since}}
+	err := core.Edit(src, dst, fakeEditor, options)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "test - change", readFile(t, dst.String()))
+}
+
+func TestEditCommandOverwriteUnreadable(t *testing.T) {
+	rootDir := t.TempDir()
+	src := createTestFile(t, rootDir, "input.txt", "test")
+	// Exists, but can't be read as a file
+	dst := testFileURL(t, rootDir, "output.txt")
+	assert.NoError(t, os.Mkdir(dst.String(), 0700))
+
+	fakeEditor := &FakeEditor{t: t, appendWith: " - change"}
+	err := core.Edit(src, dst, fakeEditor, core.Options{Overwrite: core.OverwriteIfDifferent})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "compare against")
+	assert.Equal(t, "", fakeEditor.body, "Editor not opened")
+}
+
 func TestEditCommandChangeDifferentFilesGZip(t *testing.T) {
 	inputBody := "test"
 	change := " - change"
@@ -250,7 +449,7 @@ func TestEditCommandChangeDifferentFilesGZip(t *testing.T) {
 
 	// Edit
 	fakeEditor := &FakeEditor{t: t, appendWith: change}
-	err := core.Edit(src, dst, fakeEditor)
+	err := core.Edit(src, dst, fakeEditor, core.Options{})
 
 	// Read src and dst files
 	srcBody := readFileGzip(t, src.String())
@@ -262,3 +461,871 @@ func TestEditCommandChangeDifferentFilesGZip(t *testing.T) {
 	assert.Equal(t, inputBody, srcBody)
 	assert.Equal(t, inputBody, fakeEditor.body)
 }
+
+func TestEditCommandKeepCompression(t *testing.T) {
+	cases := []struct {
+		name       string
+		outputFile string
+		keep       bool
+		read       func(t *testing.T, filename string) string
+	}{
+		{name: "drop", outputFile: "output.json", keep: false, read: readFile},
+		{name: "keep", outputFile: "output.json", keep: true, read: readFileGzip},
+		{name: "keep-explicit", outputFile: "output.json.sz", keep: true, read: readFileSnappy},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rootDir := t.TempDir()
+			src := testFileURL(t, rootDir, "input.json.gz")
+			dst := testFileURL(t, rootDir, tc.outputFile)
+
+			writeFileGzip(t, src.String(), "test")
+
+			fakeEditor := &FakeEditor{t: t, appendWith: " - change"}
+			err := core.Edit(src, dst, fakeEditor, core.Options{KeepCompression: tc.keep})
+
+			assert.NoError(t, err)
+			assert.Equal(t, "test - change", tc.read(t, dst.String()))
+		})
+	}
+}
+
+func TestEditCommandCompressionOverrides(t *testing.T) {
+	cases := []struct {
+		name                   string
+		inputFile              string
+		outputFile             string
+		write                  func(t *testing.T, filename string, data string)
+		read                   func(t *testing.T, filename string) string
+		sourceCompression      string
+		destinationCompression string
+		keep                   bool
+	}{
+		{name: "gzip source as txt", inputFile: "input.txt", outputFile: "output.txt", write: writeFileGzip, read: readFile, sourceCompression: "gzip"},
+		{name: "plain destination as gz", inputFile: "input.txt.gz", outputFile: "output.txt.gz", write: writeFileGzip, read: readFile, destinationCompression: "none"},
+		{name: "gzip to snappy", inputFile: "input.txt", outputFile: "output.txt", write: writeFileGzip, read: readFileSnappy, sourceCompression: "gzip", destinationCompression: "snappy"},
+		{name: "keep overridden source", inputFile: "input.bin", outputFile: "output.bin", write: writeFileGzip, read: readFileGzip, sourceCompression: "gzip", keep: true},
+		{name: "destination wins over keep", inputFile: "input.txt.gz", outputFile: "output.txt", write: writeFileGzip, read: readFileSnappy, destinationCompression: "snappy", keep: true},
+		{name: "auto", inputFile: "input.txt.gz", outputFile: "output.txt.sz", write: writeFileGzip, read: readFileSnappy, sourceCompression: "auto", destinationCompression: "auto"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rootDir := t.TempDir()
+			src := testFileURL(t, rootDir, tc.inputFile)
+			dst := testFileURL(t, rootDir, tc.outputFile)
+
+			tc.write(t, src.String(), "test")
+
+			fakeEditor := &FakeEditor{t: t, appendWith: " - change"}
+			options := core.Options{
+				SourceCompression:      tc.sourceCompression,
+				DestinationCompression: tc.destinationCompression,
+				KeepCompression:        tc.keep,
+			}
+			err := core.Edit(src, dst, fakeEditor, options)
+
+			assert.NoError(t, err)
+			assert.Equal(t, "test", fakeEditor.body)
+			assert.Equal(t, "test - change", tc.read(t, dst.String()))
+		})
+	}
+}
+
+func TestEditCommandExpandedDestination(t *testing.T) {
+	for _, destination := range []string{"~/output.txt", "$REMBLOB_OUT/output.txt"} {
+		t.Run(destination, func(t *testing.T) {
+			rootDir := t.TempDir()
+			t.Setenv("HOME", rootDir)
+			t.Setenv("REMBLOB_OUT", rootDir)
+			src := createTestFile(t, rootDir, "input.txt", "test")
+			dst, err := storage.ParseURI(destination)
+			assert.NoError(t, err)
+
+			fakeEditor := &FakeEditor{t: t, appendWith: " - change"}
+			err = core.Edit(src, dst, fakeEditor, core.Options{})
+
+			assert.NoError(t, err)
+			assert.Equal(t, "test - change", readFile(t, path.Join(rootDir, "output.txt")))
+		})
+	}
+}
+
+func TestEditCommandBackupSuffix(t *testing.T) {
+	rootDir := t.TempDir()
+	src := testFileURL(t, rootDir, "input.json")
+	writeFile(t, src.String(), "test")
+
+	fakeEditor := &FakeEditor{t: t, appendWith: " - change"}
+	options := core.Options{Storage: storage.Options{BackupSuffix: "~"}}
+	err := core.Edit(src, src, fakeEditor, options)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "test - change", readFile(t, src.String()))
+	assert.Equal(t, "test", readFile(t, src.String()+"~"))
+}
+
+func TestEditCommandCrossBackend(t *testing.T) {
+	rootDir := t.TempDir()
+
+	// data: to file://, with compression applied on the way
+	src, err := storage.ParseURI("data:,test")
+	assert.NoError(t, err)
+	dst, err := storage.ParseURI("file://" + path.Join(rootDir, "output.txt.gz"))
+	assert.NoError(t, err)
+
+	fakeEditor := &FakeEditor{t: t, appendWith: " - change"}
+	err = core.Edit(src, dst, fakeEditor, core.Options{Stamp: true})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "test - change", readFileGzip(t, path.Join(rootDir, "output.txt.gz")))
+
+	// Local file to a read-only backend fails before editing
+	fakeEditor = &FakeEditor{t: t, appendWith: " - change"}
+	err = core.Edit(dst, src, fakeEditor, core.Options{})
+
+	assert.ErrorIs(t, err, storage.ErrReadOnly)
+	assert.Equal(t, "", fakeEditor.body, "editor must not be opened")
+
+	// Editing read-only content in place fails before editing too
+	fakeEditor = &FakeEditor{t: t, appendWith: " - change"}
+	err = core.Edit(src, src, fakeEditor, core.Options{})
+
+	assert.ErrorIs(t, err, storage.ErrReadOnly)
+	assert.Equal(t, "", fakeEditor.body, "editor must not be opened")
+}
+
+func TestEditCommandChangeDifferentFilesSnappy(t *testing.T) {
+	inputBody := "test"
+	change := " - change"
+	expectedBody := "test - change"
+
+	for _, suffix := range []string{".sz", ".snappy"} {
+		t.Run(suffix, func(t *testing.T) {
+			// Input/Output file paths, only input exists
+			rootDir := t.TempDir()
+			src := testFileURL(t, rootDir, "input"+suffix)
+			dst := testFileURL(t, rootDir, "output"+suffix)
+
+			writeFileSnappy(t, src.String(), inputBody)
+
+			// Edit
+			fakeEditor := &FakeEditor{t: t, appendWith: change}
+			err := core.Edit(src, dst, fakeEditor, core.Options{})
+
+			// Check for changes
+			assert.NoError(t, err)
+			assert.Equal(t, expectedBody, readFileSnappy(t, dst.String()))
+			assert.Equal(t, inputBody, readFileSnappy(t, src.String()))
+			assert.Equal(t, inputBody, fakeEditor.body)
+		})
+	}
+}
+
+func TestEditCommandUTF16(t *testing.T) {
+	inputBody := "test"
+	change := " - change"
+	expectedBody := "test - change"
+
+	cases := []struct {
+		name   string
+		encode func(string) []byte
+	}{
+		{
+			name: "utf-16le",
+			encode: func(s string) []byte {
+				b := []byte{0xff, 0xfe}
+				for _, r := range s {
+					b = append(b, byte(r), 0)
+				}
+				return b
+			},
+		},
+		{
+			name: "utf-16be",
+			encode: func(s string) []byte {
+				b := []byte{0xfe, 0xff}
+				for _, r := range s {
+					b = append(b, 0, byte(r))
+				}
+				return b
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rootDir := t.TempDir()
+			src := createTestFile(t, rootDir, "input.txt", string(tc.encode(inputBody)))
+			dst := testFileURL(t, rootDir, "output.txt")
+
+			fakeEditor := &FakeEditor{t: t, appendWith: change}
+			err := core.Edit(src, dst, fakeEditor, core.Options{Encoding: "auto"})
+
+			assert.NoError(t, err)
+			// The editor works on UTF-8, the destination keeps the BOM and endianness
+			assert.Equal(t, inputBody, fakeEditor.body)
+			assert.Equal(t, string(tc.encode(expectedBody)), readFile(t, dst.String()))
+		})
+	}
+}
+
+func TestEditCommandConvertCommands(t *testing.T) {
+	rootDir := t.TempDir()
+	src := createTestFile(t, rootDir, "input.txt", "test")
+	dst := testFileURL(t, rootDir, "output.txt")
+
+	options := core.Options{InCommand: "tr a-z A-Z", OutCommand: "tr A-Z a-z"}
+	fakeEditor := &FakeEditor{t: t, appendWith: " - CHANGE"}
+	err := core.Edit(src, dst, fakeEditor, options)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "TEST", fakeEditor.body)
+	assert.Equal(t, "test - change", readFile(t, dst.String()))
+}
+
+func TestViewCommandInCommandLargeSource(t *testing.T) {
+	rootDir := t.TempDir()
+	src := createTestFile(t, rootDir, "input.txt", strings.Repeat("a line of the source\n", 150000))
+
+	// head exits long before the source is read, which must not count as a partial read
+	fakeEditor := &FakeEditor{t: t}
+	err := core.View(src, fakeEditor, core.Options{InCommand: "head -n1"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "a line of the source\n", fakeEditor.body)
+}
+
+func TestEditCommandConvertCommandFailure(t *testing.T) {
+	rootDir := t.TempDir()
+	src := createTestFile(t, rootDir, "input.txt", "test")
+	dst := testFileURL(t, rootDir, "output.txt")
+
+	options := core.Options{OutCommand: "echo broken >&2; exit 3"}
+	fakeEditor := &FakeEditor{t: t, appendWith: " - change"}
+	err := core.Edit(src, dst, fakeEditor, options)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "broken")
+	assert.NoFileExists(t, dst.String())
+}
+
+func TestExistsCommand(t *testing.T) {
+	rootDir := t.TempDir()
+	src := createTestFile(t, rootDir, "input.txt", "test")
+	missing := testFileURL(t, rootDir, "missing.txt")
+
+	exists, err := core.Exists(src, core.Options{})
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = core.Exists(missing, core.Options{})
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestEditCommandTrailingNewline(t *testing.T) {
+	cases := []struct {
+		name            string
+		input           string
+		change          string
+		trailingNewline string
+		expected        string // Empty when nothing should be written
+	}{
+		{
+			name:            "preserve-added-newline",
+			input:           "test",
+			change:          "\n",
+			trailingNewline: core.NewlinePreserve,
+			expected:        "test\n",
+		},
+		{
+			name:            "trim-added-newline",
+			input:           "test",
+			change:          "\n",
+			trailingNewline: core.NewlineTrim,
+			expected:        "",
+		},
+		{
+			name:            "trim-single-newline",
+			input:           "test",
+			change:          " - change\n\n",
+			trailingNewline: core.NewlineTrim,
+			expected:        "test - change\n",
+		},
+		{
+			name:            "ensure-no-change",
+			input:           "test",
+			change:          "",
+			trailingNewline: core.NewlineEnsure,
+			expected:        "",
+		},
+		{
+			name:            "ensure-extra-newlines",
+			input:           "test\n",
+			change:          "\n\n",
+			trailingNewline: core.NewlineEnsure,
+			expected:        "",
+		},
+		{
+			name:            "ensure-change",
+			input:           "test",
+			change:          " - change",
+			trailingNewline: core.NewlineEnsure,
+			expected:        "test - change\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rootDir := t.TempDir()
+			src := createTestFile(t, rootDir, "input.txt", tc.input)
+			dst := testFileURL(t, rootDir, "output.txt")
+
+			fakeEditor := &FakeEditor{t: t, appendWith: tc.change}
+			err := core.Edit(src, dst, fakeEditor, core.Options{TrailingNewline: tc.trailingNewline})
+
+			assert.NoError(t, err)
+			if tc.expected == "" {
+				assert.NoFileExists(t, dst.String())
+			} else {
+				assert.Equal(t, tc.expected, readFile(t, dst.String()))
+			}
+		})
+	}
+}
+
+func TestEditCommandIgnoreWhitespace(t *testing.T) {
+	cases := []struct {
+		name     string
+		change   string
+		expected string // Empty when nothing should be written
+	}{
+		{
+			name:     "whitespace-only",
+			change:   " \n\t ",
+			expected: "",
+		},
+		{
+			name:     "change",
+			change:   "  - change ",
+			expected: "test data  - change ",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rootDir := t.TempDir()
+			src := createTestFile(t, rootDir, "input.txt", "test data")
+			dst := testFileURL(t, rootDir, "output.txt")
+
+			fakeEditor := &FakeEditor{t: t, appendWith: tc.change}
+			err := core.Edit(src, dst, fakeEditor, core.Options{IgnoreWhitespace: true})
+
+			assert.NoError(t, err)
+			if tc.expected == "" {
+				assert.NoFileExists(t, dst.String())
+			} else {
+				// Written as the editor left it
+				assert.Equal(t, tc.expected, readFile(t, dst.String()))
+			}
+		})
+	}
+}
+
+func TestEditCommandCSV(t *testing.T) {
+	inputBody := "name,\"note\"\r\nfoo,\"a, b\"\r\n"
+	normalizedBody := "name,note\r\nfoo,\"a, b\"\r\n"
+
+	cases := []struct {
+		name     string
+		change   string
+		strict   bool
+		expected string // Empty when nothing should be written
+		fails    bool
+	}{
+		{
+			name:     "no-change",
+			change:   "",
+			expected: "",
+		},
+		{
+			name:     "change",
+			change:   "bar,\"c\"\n",
+			expected: normalizedBody + "bar,c\r\n",
+		},
+		{
+			name:     "uneven-records",
+			change:   "bar\n",
+			expected: normalizedBody + "bar\r\n",
+		},
+		{
+			name:   "uneven-records-strict",
+			change: "bar\n",
+			strict: true,
+			fails:  true,
+		},
+		{
+			name:   "broken-quoting",
+			change: "bar,\"c\n",
+			fails:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rootDir := t.TempDir()
+			src := createTestFile(t, rootDir, "input.csv", inputBody)
+			dst := testFileURL(t, rootDir, "output.csv")
+
+			fakeEditor := &FakeEditor{t: t, appendWith: tc.change}
+			err := core.Edit(src, dst, fakeEditor, core.Options{CSVStrict: tc.strict})
+
+			assert.Equal(t, normalizedBody, fakeEditor.body)
+			if tc.fails {
+				assert.Error(t, err)
+				assert.NoFileExists(t, dst.String())
+				return
+			}
+
+			assert.NoError(t, err)
+			if tc.expected == "" {
+				assert.NoFileExists(t, dst.String())
+			} else {
+				assert.Equal(t, tc.expected, readFile(t, dst.String()))
+			}
+		})
+	}
+}
+
+func TestEditCommandCSVPlain(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		plain    bool
+		change   string
+		expected string
+	}{
+		{
+			name:     "bare quote",
+			input:    "name,note\nfoo,5\" disk\n",
+			change:   "bar,\"c\n",
+			expected: "name,note\nfoo,5\" disk\nbar,\"c\n",
+		},
+		{
+			name:     "opted out",
+			input:    "name,\"note\"\nfoo,b\n",
+			plain:    true,
+			change:   "bar,\"c\n",
+			expected: "name,\"note\"\nfoo,b\nbar,\"c\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rootDir := t.TempDir()
+			src := createTestFile(t, rootDir, "input.csv", tc.input)
+
+			fakeEditor := &FakeEditor{t: t, appendWith: tc.change}
+			err := core.Edit(src, src, fakeEditor, core.Options{CSVPlain: tc.plain})
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.input, fakeEditor.body)
+			assert.Equal(t, tc.expected, readFile(t, src.String()))
+		})
+	}
+}
+
+func TestEditCommandCSVDelimiter(t *testing.T) {
+	cases := []struct {
+		name      string
+		file      string
+		input     string
+		delimiter rune
+		change    string
+		expected  string
+		fails     bool
+	}{
+		{
+			name:     "semicolon",
+			file:     "input.csv",
+			input:    "name;note\nfoo;a, b\n",
+			change:   "bar;\"c\"\n",
+			expected: "name;note\nfoo;a, b\nbar;c\n",
+		},
+		{
+			name:     "tab",
+			file:     "input.tsv",
+			input:    "name\tnote\nfoo\ta, b\n",
+			change:   "bar\tc\n",
+			expected: "name\tnote\nfoo\ta, b\nbar\tc\n",
+		},
+		{
+			// Falls back to tabs, so the added record has too many fields
+			name:   "tab-single-column",
+			file:   "input.tsv",
+			input:  "name\nfoo\n",
+			change: "bar\tc\n",
+			fails:  true,
+		},
+		{
+			name:      "override",
+			file:      "input.csv",
+			input:     "name|note;x\nfoo|b;y\n",
+			delimiter: '|',
+			change:    "bar|c;z\n",
+			expected:  "name|note;x\nfoo|b;y\nbar|c;z\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rootDir := t.TempDir()
+			src := createTestFile(t, rootDir, tc.file, tc.input)
+
+			fakeEditor := &FakeEditor{t: t, appendWith: tc.change}
+			options := core.Options{CSVStrict: true, CSVDelimiter: tc.delimiter}
+			err := core.Edit(src, src, fakeEditor, options)
+
+			assert.Equal(t, tc.input, fakeEditor.body)
+			if tc.fails {
+				assert.Error(t, err)
+				assert.Equal(t, tc.input, readFile(t, src.String()))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, readFile(t, src.String()))
+		})
+	}
+}
+
+func TestEditCommandBinary(t *testing.T) {
+	cases := []struct {
+		name        string
+		input       string
+		forceBinary bool
+		fails       bool
+	}{
+		{
+			name:  "text",
+			input: "test ünïcode",
+		},
+		{
+			name:  "nul-bytes",
+			input: "test\x00\x01",
+			fails: true,
+		},
+		{
+			name:  "invalid-utf8",
+			input: "test\xff\xfe\xfd",
+			fails: true,
+		},
+		{
+			name:        "forced",
+			input:       "test\x00\x01",
+			forceBinary: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rootDir := t.TempDir()
+			src := createTestFile(t, rootDir, "input.bin", tc.input)
+
+			fakeEditor := &FakeEditor{t: t, appendWith: " - change"}
+			err := core.Edit(src, src, fakeEditor, core.Options{ForceBinary: tc.forceBinary})
+
+			if tc.fails {
+				assert.ErrorIs(t, err, core.ErrBinary)
+				assert.Equal(t, tc.input, readFile(t, src.String()))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.input+" - change", readFile(t, src.String()))
+		})
+	}
+}
+
+func TestEditCommandGzipMultipleMembers(t *testing.T) {
+	rootDir := t.TempDir()
+	src := testFileURL(t, rootDir, "input.gz")
+	dst := testFileURL(t, rootDir, "output.gz")
+
+	var b bytes.Buffer
+	for _, member := range []string{"first", " second"} {
+		w := gzip.NewWriter(&b)
+		w.Write([]byte(member))
+		w.Close()
+	}
+	writeFile(t, src.String(), b.String())
+
+	fakeEditor := &FakeEditor{t: t, appendWith: " - change"}
+	err := core.Edit(src, dst, fakeEditor, core.Options{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "first second", fakeEditor.body)
+
+	// Written back as a single member
+	reader, err := os.Open(dst.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	gzreader, err := gzip.NewReader(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzreader.Multistream(false)
+	body, err := io.ReadAll(gzreader)
+	assert.NoError(t, err)
+	assert.Equal(t, "first second - change", string(body))
+	err = gzreader.Reset(reader)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestEditCommandLenientGzip(t *testing.T) {
+	cases := []struct {
+		name    string
+		lenient bool
+	}{
+		{name: "strict", lenient: false},
+		{name: "lenient", lenient: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rootDir := t.TempDir()
+			src := createTestFile(t, rootDir, "mislabeled.txt.gz", "plain text")
+
+			fakeEditor := &FakeEditor{t: t, appendWith: " - change"}
+			err := core.Edit(src, src, fakeEditor, core.Options{Lenient: tc.lenient})
+
+			if !tc.lenient {
+				assert.Error(t, err)
+				assert.Equal(t, "plain text", readFile(t, src.String()))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "plain text", fakeEditor.body)
+			// Written back as it was read
+			assert.Equal(t, "plain text - change", readFile(t, src.String()))
+		})
+	}
+}
+
+func TestEditCommandLenientGzipCompressed(t *testing.T) {
+	rootDir := t.TempDir()
+	src := testFileURL(t, rootDir, "input.txt.gz")
+	writeFileGzip(t, src.String(), "test")
+
+	fakeEditor := &FakeEditor{t: t, appendWith: " - change"}
+	err := core.Edit(src, src, fakeEditor, core.Options{Lenient: true})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "test - change", readFileGzip(t, src.String()))
+}
+
+func TestPrintCommandTruncatedGzip(t *testing.T) {
+	var body bytes.Buffer
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&body, "line %d\n", i)
+	}
+
+	cases := []struct {
+		name    string
+		cut     int // Bytes of the compressed stream kept
+		lenient bool
+	}{
+		{name: "strict", cut: 1000},
+		{name: "lenient", cut: 1000, lenient: true},
+		{name: "lenient without trailer", cut: -8, lenient: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rootDir := t.TempDir()
+			src := testFileURL(t, rootDir, "input.txt.gz")
+			writeFileGzip(t, src.String(), body.String())
+			compressed := []byte(readFile(t, src.String()))
+			cut := tc.cut
+			if cut < 0 {
+				cut += len(compressed)
+			}
+			os.WriteFile(src.String(), compressed[:cut], 0700)
+
+			var out bytes.Buffer
+			err := core.Print(src, &out, core.Options{LenientGzip: tc.lenient})
+
+			if !tc.lenient {
+				assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotEmpty(t, out.String())
+			assert.True(t, strings.HasPrefix(body.String(), out.String()), "Output is the start of the content")
+		})
+	}
+}
+
+func TestEditCommandMaxRowsInteractive(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		maxRows  int
+		expected error
+	}{
+		{name: "below", input: "a,b\n1,2\n", maxRows: 3},
+		{name: "at", input: "a,b\n1,2\n3,4\n", maxRows: 3},
+		{name: "above", input: "a,b\n1,2\n3,4\n5,6\n", maxRows: 3, expected: core.ErrTooManyRows},
+		{name: "above without newline", input: "a,b\n1,2\n3,4\n5,6", maxRows: 3, expected: core.ErrTooManyRows},
+		{name: "no limit", input: "a,b\n1,2\n3,4\n5,6\n", maxRows: 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rootDir := t.TempDir()
+			src := createTestFile(t, rootDir, "input.csv", tc.input)
+
+			fakeEditor := &FakeEditor{t: t, appendWith: "x,y\n"}
+			err := core.Edit(src, src, fakeEditor, core.Options{MaxRowsInteractive: tc.maxRows})
+
+			if tc.expected != nil {
+				assert.ErrorIs(t, err, tc.expected)
+				assert.Equal(t, tc.input, readFile(t, src.String()))
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestEditCommandMaxRowsInteractiveNotCSV(t *testing.T) {
+	rootDir := t.TempDir()
+	src := createTestFile(t, rootDir, "input.txt", "1\n2\n3\n4\n")
+
+	fakeEditor := &FakeEditor{t: t, appendWith: "5\n"}
+	err := core.Edit(src, src, fakeEditor, core.Options{MaxRowsInteractive: 3})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "1\n2\n3\n4\n5\n", readFile(t, src.String()))
+}
+
+func TestEditCommandDotenv(t *testing.T) {
+	cases := []struct {
+		name       string
+		appendWith string
+		expected   string
+	}{
+		{name: "assignment", appendWith: "C=3\n"},
+		{name: "export", appendWith: "export C='3'\n"},
+		{name: "comment and blank", appendWith: "\n# comment\n"},
+		{name: "multiline quoted", appendWith: "C=\"line 1\nline 2\"\n"},
+		{name: "no equals", appendWith: "C\n", expected: `line 4: "C"`},
+		{name: "invalid key", appendWith: "1C=3\n", expected: `line 4: "1C=3"`},
+		{name: "unterminated quote", appendWith: "C=\"3\n", expected: "unterminated \" quoted value"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rootDir := t.TempDir()
+			input := "# settings\nA=1\nB = two words\n"
+			src := createTestFile(t, rootDir, ".env", input)
+
+			fakeEditor := &FakeEditor{t: t, appendWith: tc.appendWith}
+			err := core.Edit(src, src, fakeEditor, core.Options{})
+
+			if tc.expected != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expected)
+				assert.Equal(t, input, readFile(t, src.String()))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, input+tc.appendWith, readFile(t, src.String()))
+		})
+	}
+}
+
+func TestEditCommandDotenvNames(t *testing.T) {
+	for _, name := range []string{".env", ".env.local", "prod.env", "prod.env.gz"} {
+		t.Run(name, func(t *testing.T) {
+			rootDir := t.TempDir()
+			src := createTestFile(t, rootDir, "input.txt", "A=1\n")
+			dst := testFileURL(t, rootDir, name)
+
+			fakeEditor := &FakeEditor{t: t, appendWith: "not valid\n"}
+			err := core.Edit(src, dst, fakeEditor, core.Options{})
+
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEditCommandLineRange(t *testing.T) {
+	input := "1\n2\n3\n4\n5\n"
+	cases := []struct {
+		name       string
+		first      int
+		last       int
+		appendWith string
+		edited     string // What the editor is presented
+		expected   string
+		fails      bool
+	}{
+		{name: "middle", first: 2, last: 3, appendWith: "x\n", edited: "2\n3\n", expected: "1\n2\n3\nx\n4\n5\n"},
+		{name: "middle without newline", first: 2, last: 3, appendWith: "x", edited: "2\n3\n", expected: "1\n2\n3\nx\n4\n5\n"},
+		{name: "single line", first: 1, last: 1, appendWith: "x\n", edited: "1\n", expected: "1\nx\n2\n3\n4\n5\n"},
+		{name: "to the end", first: 4, appendWith: "x", edited: "4\n5\n", expected: "1\n2\n3\n4\n5\nx"},
+		{name: "last line", first: 5, last: 5, appendWith: "x\n", edited: "5\n", expected: "1\n2\n3\n4\n5\nx\n"},
+		{name: "end out of bounds", first: 4, last: 6, fails: true},
+		{name: "start out of bounds", first: 6, fails: true},
+		{name: "reversed", first: 3, last: 2, fails: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rootDir := t.TempDir()
+			src := createTestFile(t, rootDir, "input.log", input)
+
+			fakeEditor := &FakeEditor{t: t, appendWith: tc.appendWith}
+			err := core.Edit(src, src, fakeEditor, core.Options{FirstLine: tc.first, LastLine: tc.last})
+
+			if tc.fails {
+				assert.Error(t, err)
+				assert.Equal(t, input, readFile(t, src.String()))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.edited, fakeEditor.body)
+			assert.Equal(t, tc.expected, readFile(t, src.String()))
+		})
+	}
+}
+
+func TestEditCommandLineRangeGzip(t *testing.T) {
+	rootDir := t.TempDir()
+	src := testFileURL(t, rootDir, "input.log.gz")
+	writeFileGzip(t, src.String(), "1\n2\n3\n")
+
+	fakeEditor := &FakeEditor{t: t, appendWith: "x\n"}
+	err := core.Edit(src, src, fakeEditor, core.Options{FirstLine: 2, LastLine: 2})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "2\n", fakeEditor.body)
+	assert.Equal(t, "1\n2\nx\n3\n", readFileGzip(t, src.String()))
+}
+
+func TestEditCommandIfModifiedSince(t *testing.T) {
+	rootDir := t.TempDir()
+	src := createTestFile(t, rootDir, "input.txt", "test")
+
+	options := core.Options{}
+	options.Storage.IfModifiedSince = time.Now().Add(time.Hour)
+
+	fakeEditor := &FakeEditor{t: t, appendWith: " - change"}
+	err := core.Edit(src, src, fakeEditor, options)
+
+	assert.ErrorIs(t, err, storage.ErrNotModified)
+	assert.Equal(t, "test", readFile(t, src.String()))
+}
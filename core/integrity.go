@@ -0,0 +1,34 @@
+package core
+
+import (
+	"fmt"
+	"io"
+
+	"techiecaro/remblob/storage"
+)
+
+// countingReadCloser counts the bytes read through it.
+type countingReadCloser struct {
+	io.ReadCloser
+	count int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// checkContentLength refuses sources read partially, so truncated content isn't edited and written back
+func checkContentLength(src interface{}, read int64) error {
+	capable, ok := src.(storage.ContentLengthCapable)
+	if !ok {
+		return nil
+	}
+
+	expected, known := capable.GetContentLength()
+	if known && expected != read {
+		return fmt.Errorf("Source was read partially: got %d bytes out of %d", read, expected)
+	}
+	return nil
+}
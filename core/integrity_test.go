@@ -0,0 +1,60 @@
+package core
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"techiecaro/remblob/shovel"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSizedStorage claims a content length, which may not match the body.
+type fakeSizedStorage struct {
+	io.Reader
+	length int64
+	known  bool
+}
+
+func (f *fakeSizedStorage) Close() error {
+	return nil
+}
+
+func (f *fakeSizedStorage) GetContentLength() (int64, bool) {
+	return f.length, f.known
+}
+
+func TestCheckContentLength(t *testing.T) {
+	cases := []struct {
+		name   string
+		length int64
+		known  bool
+		fails  bool
+	}{
+		{name: "complete", length: 4, known: true, fails: false},
+		{name: "short-read", length: 10, known: true, fails: true},
+		{name: "unknown", length: 0, known: false, fails: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			src := &fakeSizedStorage{Reader: strings.NewReader("test"), length: tc.length, known: tc.known}
+			err := remoteView("input.txt", src, &shovel.MultiShovel{}, noopEditor{}, Options{})
+
+			if tc.fails {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "got 4 bytes out of 10")
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+// noopEditor leaves the file unchanged.
+type noopEditor struct{}
+
+func (noopEditor) Edit(filename string) error {
+	return nil
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/md5"
 	"io"
@@ -8,16 +9,29 @@ import (
 	"techiecaro/remblob/editor"
 )
 
-func localEdit(tmp *os.File, localEditor editor.Editor) (bool, error) {
+// Handling of the trailing newline of the edited file
+const (
+	NewlinePreserve = ""       // Keep what the editor wrote
+	NewlineTrim     = "trim"   // Strip a single trailing newline
+	NewlineEnsure   = "ensure" // End with exactly one newline
+)
+
+// maxWordSize bounds the memory used when comparing content ignoring whitespace
+const maxWordSize = 64 * 1024 * 1024
+
+func localEdit(tmp *os.File, localEditor editor.Editor, options Options) (bool, error) {
 	// User editing the file
-	startHash, err := getHash(tmp)
+	startHash, err := getContentHash(tmp, options)
 	if err != nil {
 		return false, err
 	}
 	if err := localEditor.Edit(tmp.Name()); err != nil {
 		return false, err
 	}
-	endHash, err := getHash(tmp)
+	if err := normalizeTrailingNewline(tmp, options.TrailingNewline); err != nil {
+		return false, err
+	}
+	endHash, err := getContentHash(tmp, options)
 	if err != nil {
 		return false, err
 	}
@@ -27,14 +41,107 @@ func localEdit(tmp *os.File, localEditor editor.Editor) (bool, error) {
 	return changes, nil
 }
 
-func getHash(stream io.ReadSeeker) ([]byte, error) {
+// getContentHash hashes the file the way it is compared before and after editing
+func getContentHash(file *os.File, options Options) ([]byte, error) {
+	if options.IgnoreWhitespace {
+		return getWordsHash(file)
+	}
+	return getNormalizedHash(file, options.TrailingNewline)
+}
+
+// getWordsHash hashes the file ignoring the amount and kind of whitespace between words
+func getWordsHash(stream io.ReadSeeker) ([]byte, error) {
 	stream.Seek(0, io.SeekStart)
 
 	h := md5.New()
-	if _, err := io.Copy(h, stream); err != nil {
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 64*1024), maxWordSize)
+	scanner.Split(bufio.ScanWords)
+	for scanner.Scan() {
+		h.Write(scanner.Bytes())
+		h.Write([]byte{' '})
+	}
+	if err := scanner.Err(); err != nil {
 		return nil, err
 	}
 
 	stream.Seek(0, io.SeekStart)
 	return h.Sum(nil), nil
 }
+
+// getNormalizedHash hashes the file as if its trailing newline was normalized
+func getNormalizedHash(file *os.File, trailingNewline string) ([]byte, error) {
+	length, suffix, err := getNormalizedLength(file, trailingNewline)
+	if err != nil {
+		return nil, err
+	}
+
+	file.Seek(0, io.SeekStart)
+
+	h := md5.New()
+	if _, err := io.Copy(h, io.LimitReader(file, length)); err != nil {
+		return nil, err
+	}
+	h.Write(suffix)
+
+	file.Seek(0, io.SeekStart)
+	return h.Sum(nil), nil
+}
+
+// normalizeTrailingNewline rewrites the end of the file according to the trailing newline handling
+func normalizeTrailingNewline(file *os.File, trailingNewline string) error {
+	if trailingNewline == NewlinePreserve {
+		return nil
+	}
+
+	length, suffix, err := getNormalizedLength(file, trailingNewline)
+	if err != nil {
+		return err
+	}
+
+	if err := file.Truncate(length); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(suffix, length); err != nil {
+		return err
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	return err
+}
+
+// getNormalizedLength returns how much of the file to keep and what to append to it
+func getNormalizedLength(file *os.File, trailingNewline string) (int64, []byte, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, nil, err
+	}
+	size := stat.Size()
+
+	switch trailingNewline {
+	case NewlineTrim:
+		newlines, err := countTrailingNewlines(file, size, 1)
+		return size - newlines, nil, err
+	case NewlineEnsure:
+		newlines, err := countTrailingNewlines(file, size, size)
+		return size - newlines, []byte("\n"), err
+	default:
+		return size, nil, nil
+	}
+}
+
+// countTrailingNewlines counts up to limit newlines at the end of the file
+func countTrailingNewlines(file *os.File, size int64, limit int64) (int64, error) {
+	newlines := int64(0)
+	b := make([]byte, 1)
+	for newlines < limit && newlines < size {
+		if _, err := file.ReadAt(b, size-newlines-1); err != nil {
+			return 0, err
+		}
+		if b[0] != '\n' {
+			break
+		}
+		newlines++
+	}
+	return newlines, nil
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"net/url"
+	"os"
+	"path"
+	"time"
+
+	"techiecaro/remblob/storage"
+)
+
+const (
+	editedByKey = "remblob-edited-by"
+	editedAtKey = "remblob-edited-at"
+	commentKey  = "remblob-comment"
+
+	contentDispositionHeader = "Content-Disposition"
+)
+
+// formatHeaders describe the bytes stored, so only suit a destination stored the same way
+var formatHeaders = []string{"Content-Type"}
+
+// inPlaceHeaders were set on the very object read, so only suit writing it back in place, stored the same way
+var inPlaceHeaders = []string{
+	"Content-Encoding",
+	"X-Amz-Object-Lock-Mode",
+	"X-Amz-Object-Lock-Retain-Until-Date",
+	"X-Amz-Object-Lock-Legal-Hold",
+}
+
+// headerScope tells which of the source headers suit the destination
+type headerScope struct {
+	inPlace    bool // The destination is the object read
+	sameFormat bool // The destination has the extension and compression of the source
+}
+
+// getHeaderScope finds which of the source headers suit the destination
+func getHeaderScope(source url.URL, destination url.URL, inPlace bool, options Options) headerScope {
+	sourceCompression := getSourceCompression(source, options.SourceCompression)
+	destinationCompression := getDestinationCompression(sourceCompression, destination, options.DestinationCompression, options.KeepCompression)
+	return headerScope{
+		inPlace: inPlace,
+		sameFormat: sourceCompression == destinationCompression &&
+			path.Ext(getBaseName(source)) == path.Ext(getBaseName(destination)),
+	}
+}
+
+// transferMetadata carries the metadata of the source over to the destination, stamping it if asked to
+func transferMetadata(src interface{}, dst interface{}, options Options) {
+	destination, ok := dst.(storage.MetadataCapable)
+	if !ok {
+		return
+	}
+
+	metadata := map[string]string{}
+	if source, ok := src.(storage.MetadataCapable); ok {
+		for key, value := range source.GetMetadata() {
+			metadata[key] = value
+		}
+	}
+
+	if options.Stamp || options.Comment != "" {
+		stampMetadata(metadata, options.Comment, time.Now())
+	}
+
+	destination.SetMetadata(metadata)
+}
+
+// transferHeaders carries the HTTP headers of the source over to the destination, overriding the ones given in options.
+// Headers out of the scope of the destination are dropped.
+func transferHeaders(src interface{}, dst interface{}, scope headerScope, options Options) {
+	destination, ok := dst.(storage.HeadersCapable)
+	if !ok {
+		return
+	}
+
+	headers := map[string]string{}
+	if source, ok := src.(storage.HeadersCapable); ok {
+		for name, value := range source.GetHeaders() {
+			headers[name] = value
+		}
+	}
+
+	if !scope.sameFormat {
+		deleteHeaders(headers, formatHeaders)
+	}
+	if !scope.inPlace || !scope.sameFormat {
+		deleteHeaders(headers, inPlaceHeaders)
+	}
+
+	if options.ContentDisposition != "" {
+		headers[contentDispositionHeader] = options.ContentDisposition
+	}
+
+	destination.SetHeaders(headers)
+}
+
+func deleteHeaders(headers map[string]string, names []string) {
+	for _, name := range names {
+		delete(headers, name)
+	}
+}
+
+// stampMetadata records who edited the file, when and why
+func stampMetadata(metadata map[string]string, comment string, now time.Time) {
+	metadata[editedByKey] = os.Getenv("USER")
+	metadata[editedAtKey] = now.UTC().Format(time.RFC3339)
+	if comment != "" {
+		metadata[commentKey] = comment
+	}
+}
@@ -0,0 +1,218 @@
+package core
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeMetadataStorage struct {
+	readMetadata  map[string]string
+	writeMetadata map[string]string
+}
+
+func (f *fakeMetadataStorage) GetMetadata() map[string]string {
+	return f.readMetadata
+}
+
+func (f *fakeMetadataStorage) SetMetadata(metadata map[string]string) {
+	f.writeMetadata = metadata
+}
+
+type fakeHeadersStorage struct {
+	readHeaders  map[string]string
+	writeHeaders map[string]string
+}
+
+func (f *fakeHeadersStorage) GetHeaders() map[string]string {
+	return f.readHeaders
+}
+
+func (f *fakeHeadersStorage) SetHeaders(headers map[string]string) {
+	f.writeHeaders = headers
+}
+
+func TestTransferHeaders(t *testing.T) {
+	cases := []struct {
+		name     string
+		src      interface{}
+		scope    headerScope
+		options  Options
+		expected map[string]string
+	}{
+		{
+			name:     "kept",
+			src:      &fakeHeadersStorage{readHeaders: map[string]string{"Content-Disposition": "inline"}},
+			expected: map[string]string{"Content-Disposition": "inline"},
+		},
+		{
+			name:     "in place",
+			src:      &fakeHeadersStorage{readHeaders: lockedHeaders()},
+			scope:    headerScope{inPlace: true, sameFormat: true},
+			expected: lockedHeaders(),
+		},
+		{
+			name:  "other key",
+			src:   &fakeHeadersStorage{readHeaders: lockedHeaders()},
+			scope: headerScope{sameFormat: true},
+			expected: map[string]string{
+				"Cache-Control": "no-cache",
+				"Content-Type":  "application/json",
+			},
+		},
+		{
+			name:  "other key and compression",
+			src:   &fakeHeadersStorage{readHeaders: lockedHeaders()},
+			scope: headerScope{},
+			expected: map[string]string{
+				"Cache-Control": "no-cache",
+			},
+		},
+		{
+			name:  "in place with other compression",
+			src:   &fakeHeadersStorage{readHeaders: lockedHeaders()},
+			scope: headerScope{inPlace: true},
+			expected: map[string]string{
+				"Cache-Control": "no-cache",
+			},
+		},
+		{
+			name:     "set",
+			src:      &fakeHeadersStorage{readHeaders: map[string]string{"Content-Disposition": "inline"}},
+			options:  Options{ContentDisposition: "attachment; filename=a.txt"},
+			expected: map[string]string{"Content-Disposition": "attachment; filename=a.txt"},
+		},
+		{
+			name:     "set from a source without headers",
+			src:      &fakeMetadataStorage{},
+			options:  Options{ContentDisposition: "inline"},
+			expected: map[string]string{"Content-Disposition": "inline"},
+		},
+		{
+			name:     "none",
+			src:      &fakeMetadataStorage{},
+			expected: map[string]string{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := &fakeHeadersStorage{}
+
+			transferHeaders(tc.src, dst, tc.scope, tc.options)
+
+			assert.Equal(t, tc.expected, dst.writeHeaders)
+		})
+	}
+}
+
+func lockedHeaders() map[string]string {
+	return map[string]string{
+		"Cache-Control":                       "no-cache",
+		"Content-Encoding":                    "gzip",
+		"Content-Type":                        "application/json",
+		"X-Amz-Object-Lock-Mode":              "GOVERNANCE",
+		"X-Amz-Object-Lock-Retain-Until-Date": "2030-01-01T00:00:00Z",
+		"X-Amz-Object-Lock-Legal-Hold":        "ON",
+	}
+}
+
+func TestGetHeaderScope(t *testing.T) {
+	cases := []struct {
+		name        string
+		source      string
+		destination string
+		inPlace     bool
+		expected    headerScope
+	}{
+		{"in place", "s3://bucket/a.json.gz", "s3://bucket/a.json.gz", true, headerScope{inPlace: true, sameFormat: true}},
+		{"other key", "s3://bucket/a.json.gz", "s3://bucket/b.json.gz", false, headerScope{sameFormat: true}},
+		{"decompressed", "s3://bucket/a.json.gz", "s3://bucket/a.json", false, headerScope{}},
+		{"other extension", "s3://bucket/a.json", "s3://bucket/a.csv", false, headerScope{}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			source, _ := url.Parse(tc.source)
+			destination, _ := url.Parse(tc.destination)
+
+			assert.Equal(t, tc.expected, getHeaderScope(*source, *destination, tc.inPlace, Options{}))
+		})
+	}
+}
+
+func TestStampMetadata(t *testing.T) {
+	t.Setenv("USER", "someone")
+	now := time.Date(2021, 9, 1, 12, 30, 0, 0, time.UTC)
+
+	metadata := map[string]string{"team": "x"}
+	stampMetadata(metadata, "fixing a typo", now)
+
+	expected := map[string]string{
+		"team":              "x",
+		"remblob-edited-by": "someone",
+		"remblob-edited-at": "2021-09-01T12:30:00Z",
+		"remblob-comment":   "fixing a typo",
+	}
+	assert.Equal(t, expected, metadata)
+}
+
+func TestTransferMetadata(t *testing.T) {
+	cases := []struct {
+		name     string
+		options  Options
+		expected []string
+	}{
+		{
+			name:     "no-stamp",
+			options:  Options{},
+			expected: []string{"team"},
+		},
+		{
+			name:     "stamp",
+			options:  Options{Stamp: true},
+			expected: []string{"remblob-edited-at", "remblob-edited-by", "team"},
+		},
+		{
+			name:     "comment",
+			options:  Options{Comment: "why"},
+			expected: []string{"remblob-comment", "remblob-edited-at", "remblob-edited-by", "team"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			src := &fakeMetadataStorage{readMetadata: map[string]string{"team": "x"}}
+			dst := &fakeMetadataStorage{}
+
+			transferMetadata(src, dst, tc.options)
+
+			keys := []string{}
+			for key := range dst.writeMetadata {
+				keys = append(keys, key)
+			}
+			assert.ElementsMatch(t, tc.expected, keys)
+			// Source is left untouched
+			assert.Equal(t, map[string]string{"team": "x"}, src.readMetadata)
+		})
+	}
+}
+
+func TestTransferMetadataMixedBackends(t *testing.T) {
+	plain := struct{}{} // e.g. a local file, keeping no metadata
+
+	// Metadata is dropped when the destination can't keep it
+	src := &fakeMetadataStorage{readMetadata: map[string]string{"team": "x"}}
+	transferMetadata(src, plain, Options{Stamp: true})
+	assert.Equal(t, map[string]string{"team": "x"}, src.readMetadata)
+
+	// Only the stamp is written when the source has no metadata
+	dst := &fakeMetadataStorage{}
+	transferMetadata(plain, dst, Options{})
+	assert.Empty(t, dst.writeMetadata)
+
+	transferMetadata(plain, dst, Options{Stamp: true})
+	assert.Contains(t, dst.writeMetadata, editedByKey)
+}
@@ -4,19 +4,136 @@ import (
 	"net/url"
 	"path"
 	"strings"
+
+	"techiecaro/remblob/shovel"
+	"techiecaro/remblob/storage"
 )
 
-const gzipSuffix = ".gz"
+const (
+	csvSuffix = ".csv"
+	tsvSuffix = ".tsv"
+
+	dotenvName = ".env"
+)
+
+var compressionSuffixes = map[string]shovel.Compression{
+	".gz":     shovel.GzipCompression,
+	".sz":     shovel.SnappyCompression,
+	".snappy": shovel.SnappyCompression,
+}
+
+// getCompression checks which compression/decompression the filename should go through
+func getCompression(fileURL url.URL) shovel.Compression {
+	if compression, ok := compressionSuffixes[path.Ext(fileURL.String())]; ok {
+		return compression
+	}
+	return shovel.NoCompression
+}
+
+// compressionNames override the compression told by the extension. Other names, e.g. "auto", don't
+var compressionNames = map[string]shovel.Compression{
+	"none":   shovel.NoCompression,
+	"gzip":   shovel.GzipCompression,
+	"snappy": shovel.SnappyCompression,
+}
+
+// getSourceCompression is the source's compression, the named one if given
+func getSourceCompression(source url.URL, name string) shovel.Compression {
+	if compression, ok := compressionNames[name]; ok {
+		return compression
+	}
+	return getCompression(source)
+}
+
+// getDestinationCompression is the destination's compression, the named one if given. With keep, a destination
+// without a compression extension inherits the source's compression instead of dropping it.
+func getDestinationCompression(sourceCompression shovel.Compression, destination url.URL, name string, keep bool) shovel.Compression {
+	if compression, ok := compressionNames[name]; ok {
+		return compression
+	}
+	compression := getCompression(destination)
+	if keep && compression == shovel.NoCompression {
+		return sourceCompression
+	}
+	return compression
+}
+
+// mediaTypeSuffixes pick extensions by media type, e.g. for editors to highlight the syntax
+var mediaTypeSuffixes = map[string]string{
+	"text/csv":                  csvSuffix,
+	"text/tab-separated-values": tsvSuffix,
+	"text/plain":                ".txt",
+	"text/markdown":             ".md",
+	"text/html":                 ".html",
+	"text/xml":                  ".xml",
+	"text/yaml":                 ".yaml",
+	"application/json":          ".json",
+	"application/xml":           ".xml",
+	"application/yaml":          ".yaml",
+	"application/x-yaml":        ".yaml",
+}
 
-// IsCompressed checks should the filename go though the compression/decompression
-func isCompressed(fileURL url.URL) bool {
-	return path.Ext(fileURL.String()) == gzipSuffix
+// getMediaTypeSuffix returns the extension of a media type, ignoring its parameters
+func getMediaTypeSuffix(mediaType string) (string, bool) {
+	mediaType = strings.TrimSpace(strings.SplitN(mediaType, ";", 2)[0])
+	suffix, ok := mediaTypeSuffixes[strings.ToLower(mediaType)]
+	return suffix, ok
 }
 
 func getBaseName(fileURL url.URL) string {
+	if fileURL.Scheme == "data" {
+		// Named by the media type, so e.g. CSV goes through the CSV normalization
+		if suffix, ok := getMediaTypeSuffix(strings.SplitN(fileURL.Opaque, ",", 2)[0]); ok {
+			return "data" + suffix
+		}
+		return "data.txt"
+	}
+
 	baseName := path.Base(fileURL.String())
-	if isCompressed(fileURL) {
-		baseName = strings.TrimSuffix(baseName, gzipSuffix)
+	if getCompression(fileURL) != shovel.NoCompression {
+		baseName = strings.TrimSuffix(baseName, path.Ext(baseName))
+	}
+	return baseName
+}
+
+// getTempBaseName names the local copy for the editor. When the source has no extension,
+// one is picked by its content type, if the storage can tell it without downloading.
+func getTempBaseName(source url.URL, src interface{}) string {
+	baseName := getBaseName(source)
+	if path.Ext(baseName) != "" {
+		return baseName
+	}
+
+	typed, ok := src.(storage.ContentTypeCapable)
+	if !ok {
+		return baseName
+	}
+	contentType, err := typed.GetContentType()
+	if err != nil {
+		return baseName
+	}
+	if suffix, ok := getMediaTypeSuffix(contentType); ok {
+		return baseName + suffix
 	}
 	return baseName
 }
+
+// isCSV checks should the file go through the CSV normalization
+func isCSV(fileURL url.URL) bool {
+	ext := path.Ext(getBaseName(fileURL))
+	return ext == csvSuffix || ext == tsvSuffix
+}
+
+// isDotenv checks should the file be validated as dotenv, e.g. .env, .env.local or prod.env
+func isDotenv(fileURL url.URL) bool {
+	baseName := getBaseName(fileURL)
+	return baseName == dotenvName || strings.HasPrefix(baseName, dotenvName+".") || path.Ext(baseName) == dotenvName
+}
+
+// getFallbackDelimiter is the delimiter used when it can't be detected
+func getFallbackDelimiter(fileURL url.URL) rune {
+	if path.Ext(getBaseName(fileURL)) == tsvSuffix {
+		return '\t'
+	}
+	return ','
+}
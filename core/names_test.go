@@ -0,0 +1,44 @@
+package core
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeTypedStorage struct {
+	contentType string
+	err         error
+}
+
+func (f fakeTypedStorage) GetContentType() (string, error) {
+	return f.contentType, f.err
+}
+
+func TestGetTempBaseName(t *testing.T) {
+	cases := []struct {
+		name     string
+		uri      string
+		src      interface{}
+		expected string
+	}{
+		{name: "extension", uri: "s3://bucket/blob.txt", src: fakeTypedStorage{contentType: "application/json"}, expected: "blob.txt"},
+		{name: "compressed", uri: "s3://bucket/blob.csv.gz", src: fakeTypedStorage{contentType: "application/gzip"}, expected: "blob.csv"},
+		{name: "content-type", uri: "s3://bucket/export", src: fakeTypedStorage{contentType: "application/json"}, expected: "export.json"},
+		{name: "parameters", uri: "s3://bucket/export", src: fakeTypedStorage{contentType: "text/csv; charset=utf-8"}, expected: "export.csv"},
+		{name: "unknown-type", uri: "s3://bucket/export", src: fakeTypedStorage{contentType: "application/octet-stream"}, expected: "export"},
+		{name: "head-failure", uri: "s3://bucket/export", src: fakeTypedStorage{err: errors.New("denied")}, expected: "export"},
+		{name: "not-capable", uri: "s3://bucket/export", src: struct{}{}, expected: "export"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			uri, err := url.Parse(tc.uri)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expected, getTempBaseName(*uri, tc.src))
+		})
+	}
+}
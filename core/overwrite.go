@@ -0,0 +1,106 @@
+package core
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+
+	"techiecaro/remblob/shovel"
+	"techiecaro/remblob/storage"
+)
+
+// When the destination is written
+const (
+	OverwriteAlways      = "always"       // Write whatever is there
+	OverwriteNever       = "never"        // Fail when the destination exists
+	OverwriteIfDifferent = "if-different" // Write only content different from the destination's
+)
+
+// ErrDestinationExists is returned when the destination exists and must not be overwritten
+var ErrDestinationExists = errors.New("Destination exists, not overwriting it")
+
+// checkDestination refuses existing destinations when they must never be overwritten
+func checkDestination(destination url.URL, dst storage.FileStorage, overwrite string) error {
+	if overwrite != OverwriteNever {
+		return nil
+	}
+
+	exists, err := destinationExists(destination, dst)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("%w: %s", ErrDestinationExists, destination.String())
+	}
+	return nil
+}
+
+// checkWritable refuses read-only destinations, before the editor is opened for nothing
+func checkWritable(dst storage.FileStorage) error {
+	checker, ok := dst.(storage.WriteChecker)
+	if !ok {
+		return nil
+	}
+	return checker.CheckWritable()
+}
+
+// getCurrentHash is the MD5 of the destination's content to compare against, nil when it's always written or doesn't exist yet.
+// It is read before editing, so a failing read doesn't lose the edit, through another storage instance,
+// as reading and writing the same one isn't supported.
+func getCurrentHash(destination url.URL, dst storage.FileStorage, options Options) ([]byte, error) {
+	if options.Overwrite != OverwriteIfDifferent {
+		return nil, nil
+	}
+
+	exists, err := destinationExists(destination, dst)
+	if err != nil || !exists {
+		return nil, err
+	}
+	current, err := storage.GetFileStorage(destination, options.Storage)
+	if err != nil {
+		return nil, err
+	}
+	defer current.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, current); err != nil {
+		return nil, fmt.Errorf("Could not read the destination to compare against: %w", err)
+	}
+	return hash.Sum(nil), nil
+}
+
+func destinationExists(destination url.URL, dst storage.FileStorage) (bool, error) {
+	checker, ok := dst.(storage.ExistenceChecker)
+	if !ok {
+		return false, fmt.Errorf("Can not check existence of this uri: %#v", destination.String())
+	}
+	return checker.Exists()
+}
+
+// copyOutIfDifferent writes the edited file unless its output hashes as what the destination already has.
+// Without the current hash to compare against, it is written. The output is buffered to compare it.
+func copyOutIfDifferent(dst io.WriteCloser, currentHash []byte, tmp io.ReadCloser, fileShovel shovel.Shovel) error {
+	if currentHash == nil {
+		return fileShovel.CopyOut(dst, tmp)
+	}
+
+	output := &bytes.Buffer{}
+	if err := fileShovel.CopyOut(nopWriteCloser{output}, tmp); err != nil {
+		return err
+	}
+
+	outputHash := md5.Sum(output.Bytes())
+	if bytes.Equal(currentHash, outputHash[:]) {
+		fmt.Println("Destination has the same content, not writing to it")
+		return nil
+	}
+
+	if _, err := io.Copy(dst, output); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path"
+)
+
+// ErrTooManyRows is returned when a table is too big to be edited by hand
+var ErrTooManyRows = errors.New("Table has too many rows to open in an editor. " +
+	"Transform it with --filter-cmd or --in-cmd/--out-cmd, raise --max-rows-interactive, or use --yes")
+
+// checkRowCount refuses CSV/TSV files having more lines, the header included, than maxRows. Zero means no limit
+func checkRowCount(baseName string, file *os.File, maxRows int) error {
+	ext := path.Ext(baseName)
+	if maxRows <= 0 || (ext != csvSuffix && ext != tsvSuffix) {
+		return nil
+	}
+
+	defer file.Seek(0, io.SeekStart)
+
+	file.Seek(0, io.SeekStart)
+	buffer := make([]byte, 32*1024)
+	rows := 0
+	lastNewline := true
+	for {
+		n, err := file.Read(buffer)
+		rows += bytes.Count(buffer[:n], []byte{'\n'})
+		if n > 0 {
+			lastNewline = buffer[n-1] == '\n'
+		}
+		if rows > maxRows {
+			return ErrTooManyRows
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// The last line may be missing its newline
+	if !lastNewline {
+		rows++
+	}
+	if rows > maxRows {
+		return ErrTooManyRows
+	}
+	return nil
+}
@@ -1,35 +1,83 @@
 package editor
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"time"
+
+	"golang.org/x/term"
 )
 
+// ErrNotInteractive is returned when the default editor can't run without a terminal.
+var ErrNotInteractive = errors.New("Not running in a terminal, can't start the default editor (vim). " +
+	"Set EDITOR to a non-interactive command, or use view --stdout")
+
+// ErrTimeout is returned when the editor was killed for running too long.
+var ErrTimeout = errors.New("Editor timed out")
+
 // An Editor applies modifications to local copy of the file.
 type Editor interface {
 	Edit(filename string) error
 }
 
-type EnvEditor struct{}
+// An EnvEditor runs the editor configured for the file's extension, or else $EDITOR, or else Command.
+type EnvEditor struct {
+	Timeout    time.Duration     // Kills the editor running longer. Zero means no timeout
+	Command    string            // Used when $EDITOR isn't set
+	Extensions map[string]string // Used instead of $EDITOR and Command for files with the extension, e.g. ".csv"
+}
 
-func (e EnvEditor) getEditor() []string {
-	editor := os.Getenv("EDITOR")
+func (e EnvEditor) getEditor(filename string) ([]string, error) {
+	editor := e.Extensions[strings.ToLower(filepath.Ext(filename))]
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = e.Command
+	}
 	if editor == "" {
+		if !isInteractive() {
+			return nil, ErrNotInteractive
+		}
 		editor = "vim"
 	}
 
-	return strings.Fields(editor)
+	return strings.Fields(editor), nil
 }
 
 func (e EnvEditor) Edit(filename string) error {
-	editor := e.getEditor()
+	editor, err := e.getEditor(filename)
+	if err != nil {
+		return err
+	}
 
 	editCmd := append(editor, filename)
 
-	cmd := exec.Command(editCmd[0], editCmd[1:]...)
+	ctx := context.Background()
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, editCmd[0], editCmd[1:]...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr // Editor diagnostics, e.g. failing plugins
+
+	err = cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %v", ErrTimeout, e.Timeout)
+	}
+	return err
+}
 
-	return cmd.Run()
+// isInteractive checks if the editor is connected to a terminal
+func isInteractive() bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
 }
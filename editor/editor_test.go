@@ -0,0 +1,197 @@
+package editor
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvEditorNotInteractive(t *testing.T) {
+	t.Setenv("EDITOR", "")
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	defer writer.Close()
+
+	stdin := os.Stdin
+	os.Stdin = reader
+	defer func() { os.Stdin = stdin }()
+
+	err = EnvEditor{}.Edit("not-in-use.txt")
+
+	assert.ErrorIs(t, err, ErrNotInteractive)
+}
+
+func TestEnvEditorNotInteractiveWithEditor(t *testing.T) {
+	t.Setenv("EDITOR", "true")
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	defer writer.Close()
+
+	stdin := os.Stdin
+	os.Stdin = reader
+	defer func() { os.Stdin = stdin }()
+
+	// A non-interactive EDITOR runs fine without a terminal
+	err = EnvEditor{}.Edit("not-in-use.txt")
+
+	assert.NoError(t, err)
+}
+
+func TestEnvEditorCommand(t *testing.T) {
+	t.Setenv("EDITOR", "")
+
+	// The command is used without EDITOR
+	assert.NoError(t, EnvEditor{Command: "true"}.Edit("not-in-use.txt"))
+	assert.Error(t, EnvEditor{Command: "false"}.Edit("not-in-use.txt"))
+
+	// EDITOR wins over the command
+	t.Setenv("EDITOR", "true")
+	assert.NoError(t, EnvEditor{Command: "false"}.Edit("not-in-use.txt"))
+}
+
+func TestEnvEditorExtensions(t *testing.T) {
+	t.Setenv("EDITOR", "")
+	e := EnvEditor{Command: "false", Extensions: map[string]string{".csv": "true"}}
+
+	// The extension's command wins over the command
+	assert.NoError(t, e.Edit("table.csv"))
+	assert.NoError(t, e.Edit("TABLE.CSV"))
+	assert.Error(t, e.Edit("table.tsv"))
+	assert.Error(t, e.Edit("csv"))
+
+	// The extension's command wins over EDITOR too, which wins over the command
+	t.Setenv("EDITOR", "false")
+	assert.NoError(t, e.Edit("table.csv"))
+	t.Setenv("EDITOR", "true")
+	assert.NoError(t, e.Edit("table.tsv"))
+}
+
+func TestEnvEditorStderr(t *testing.T) {
+	dir := t.TempDir()
+	script := path.Join(dir, "complaining-editor")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho \"can't load plugin\" >&2\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("EDITOR", script)
+
+	stderrFile, err := os.Create(path.Join(dir, "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderrFile.Close()
+
+	stderr := os.Stderr
+	os.Stderr = stderrFile
+	defer func() { os.Stderr = stderr }()
+
+	err = EnvEditor{}.Edit("not-in-use.txt")
+	assert.NoError(t, err)
+
+	body, _ := os.ReadFile(stderrFile.Name())
+	assert.Equal(t, "can't load plugin\n", string(body))
+}
+
+func TestEnvEditorTimeout(t *testing.T) {
+	// A stuck editor, ignoring the file it's given
+	script := path.Join(t.TempDir(), "stuck-editor")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nexec sleep 5\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("EDITOR", script)
+
+	start := time.Now()
+	err := EnvEditor{Timeout: 100 * time.Millisecond}.Edit("not-in-use.txt")
+
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestEnvEditorWithinTimeout(t *testing.T) {
+	t.Setenv("EDITOR", "true")
+
+	err := EnvEditor{Timeout: 5 * time.Second}.Edit("not-in-use.txt")
+
+	assert.NoError(t, err)
+}
+
+func TestFilterEditor(t *testing.T) {
+	filename := path.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(filename, []byte("test"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := FilterEditor{Command: "tr a-z A-Z"}.Edit(filename)
+	assert.NoError(t, err)
+
+	body, _ := os.ReadFile(filename)
+	assert.Equal(t, "TEST", string(body))
+}
+
+func TestFilterEditorFailure(t *testing.T) {
+	filename := path.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(filename, []byte("test"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := FilterEditor{Command: "echo broken >&2; exit 1"}.Edit(filename)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "broken")
+
+	// Left untouched
+	body, _ := os.ReadFile(filename)
+	assert.Equal(t, "test", string(body))
+}
+
+func TestFilterEditorTimeout(t *testing.T) {
+	filename := path.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(filename, []byte("test"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := FilterEditor{Command: "exec sleep 10", Timeout: 50 * time.Millisecond}.Edit(filename)
+	assert.ErrorIs(t, err, ErrTimeout)
+
+	// Left untouched
+	body, _ := os.ReadFile(filename)
+	assert.Equal(t, "test", string(body))
+}
+
+func TestPager(t *testing.T) {
+	dir := t.TempDir()
+	filename := path.Join(dir, "file.txt")
+	if err := os.WriteFile(filename, []byte("test"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	paged := path.Join(dir, "paged.txt")
+	err := Pager{Command: "cat > " + paged}.Edit(filename)
+	assert.NoError(t, err)
+
+	body, _ := os.ReadFile(paged)
+	assert.Equal(t, "test", string(body))
+	// Left untouched
+	body, _ = os.ReadFile(filename)
+	assert.Equal(t, "test", string(body))
+}
+
+func TestPagerFailure(t *testing.T) {
+	filename := path.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(filename, []byte("test"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Pager{Command: "exit 1"}.Edit(filename)
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,51 @@
+package editor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// A FilterEditor replaces the file with the output of a shell command reading it from stdin.
+type FilterEditor struct {
+	Command string
+	Timeout time.Duration // Kills the command running longer. Zero means no timeout
+}
+
+func (f FilterEditor) Edit(filename string) error {
+	body, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	ctx := context.Background()
+	if f.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", f.Command)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err = cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %v", ErrTimeout, f.Timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("Filter %#v failed: %v %s", f.Command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	// Rewritten in place, the file stays open by the caller
+	return os.WriteFile(filename, stdout.Bytes(), 0)
+}
@@ -0,0 +1,26 @@
+package editor
+
+import (
+	"os"
+	"os/exec"
+)
+
+// A Pager shows the file read-only, piping it through a shell command such as "less".
+type Pager struct {
+	Command string
+}
+
+func (p Pager) Edit(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	cmd := exec.Command("sh", "-c", p.Command)
+	cmd.Stdin = file
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"net/url"
 	"os"
+	"reflect"
 	"techiecaro/remblob/cli"
 
 	"github.com/alecthomas/kong"
@@ -14,7 +16,14 @@ const appDescription = `
 	Example executions:
 	remblob edit s3://a-bucket/path/blob.json
 	remblob edit blob.json s3://a-bucket/path/blob.json.gz
+	remblob edit s3://a-bucket/path/blob.json.sz
+	remblob edit --filter-cmd 'jq .' s3://a-bucket/path/blob.json
 	remblob view s3://a-bucket/path/blob.json
+	remblob view --stdout s3://a-bucket/path/blob.json.gz
+	remblob view --pager s3://a-bucket/path/blob.json
+	remblob set-meta --content-type application/json --meta team=x s3://a-bucket/path/blob.json
+	remblob doctor --output json
+	remblob versions s3://a-bucket/path/blob.json
 `
 
 func main() {
@@ -23,6 +32,9 @@ func main() {
 		kong.Name(appName),
 		kong.Description(appDescription),
 		kong.UsageOnError(),
+		cli.Configuration(),
+		kong.TypeMapper(reflect.TypeOf(url.URL{}), cli.URIMapper{}),
+		kong.TypeMapper(reflect.TypeOf(&url.URL{}), cli.URIMapper{}),
 	)
 
 	cli.AddCompletion(parser)
@@ -0,0 +1,67 @@
+package shovel
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// A CommandShovel pipes data through external commands around another shovel.
+// The commands read from stdin and write to stdout. Empty command passes the data as is.
+type CommandShovel struct {
+	Shovel     Shovel
+	InCommand  string
+	OutCommand string
+}
+
+// CopyIn copies data from reader to writer while converting it with the in command. Then it closes the reader.
+func (c CommandShovel) CopyIn(dst io.WriteCloser, src io.ReadCloser) error {
+	if c.InCommand == "" {
+		return c.Shovel.CopyIn(dst, src)
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		pipeWriter.CloseWithError(c.Shovel.CopyIn(pipeWriter, src))
+	}()
+	defer pipeReader.Close()
+
+	if err := runCommand(c.InCommand, pipeReader, dst); err != nil {
+		return err
+	}
+	// Commands may exit before reading all their input, e.g. head. The source is still read to its end,
+	// so a partial read can be told apart from a complete one
+	_, err := io.Copy(io.Discard, pipeReader)
+	return err
+}
+
+// CopyOut copies data from reader to writer while converting it with the out command. Then it closes the writer.
+// The command output is buffered, so nothing gets written when the command fails.
+func (c CommandShovel) CopyOut(dst io.WriteCloser, src io.ReadCloser) error {
+	if c.OutCommand == "" {
+		return c.Shovel.CopyOut(dst, src)
+	}
+
+	converted := &bytes.Buffer{}
+	if err := runCommand(c.OutCommand, src, converted); err != nil {
+		return err
+	}
+
+	return c.Shovel.CopyOut(dst, readCloser{Reader: converted, Closer: src})
+}
+
+func runCommand(command string, stdin io.Reader, stdout io.Writer) error {
+	stderr := &bytes.Buffer{}
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Command %#v failed: %v %s", command, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
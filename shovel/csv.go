@@ -0,0 +1,153 @@
+package shovel
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+)
+
+// csvDelimiters are the candidates considered when detecting the delimiter
+var csvDelimiters = []rune{',', ';', '\t', '|'}
+
+// A CSVShovel normalizes the quoting of CSV for editing and validates it before writing.
+// Line endings are kept as found on the header line. CSV which can't be parsed is edited as plain text.
+// It wraps another shovel, which handles the compression.
+type CSVShovel struct {
+	Shovel        Shovel
+	Strict        bool // Requires all the records to have the same number of fields
+	Comma         rune // Delimiter of the fields. Zero detects it from the header line
+	FallbackComma rune // Used when detection finds no delimiter. Zero means a comma
+
+	detected rune
+	crlf     bool // The source ends its lines with CRLF
+	plain    bool // The source isn't valid CSV, so it's neither normalized nor validated
+}
+
+// CopyIn copies data from reader to writer while normalizing the CSV. Then it closes the reader.
+func (c *CSVShovel) CopyIn(dst io.WriteCloser, src io.ReadCloser) error {
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		pipeWriter.CloseWithError(c.Shovel.CopyIn(pipeWriter, src))
+	}()
+	defer pipeReader.Close()
+
+	bufferedReader := bufio.NewReader(pipeReader)
+	c.detected = c.Comma
+	if c.detected == 0 {
+		c.detected = c.detect(bufferedReader)
+	}
+
+	c.crlf = isCRLF(bufferedReader)
+
+	// Kept, to be edited as is if it doesn't parse
+	raw, err := io.ReadAll(bufferedReader)
+	if err != nil {
+		return err
+	}
+
+	reader := csv.NewReader(bytes.NewReader(raw))
+	reader.FieldsPerRecord = -1
+	reader.Comma = c.detected
+
+	normalized := &bytes.Buffer{}
+	writer := csv.NewWriter(normalized)
+	writer.Comma = c.detected
+	writer.UseCRLF = c.crlf
+
+	if err := copyCSV(writer, reader); err != nil {
+		fmt.Fprintf(os.Stderr, "Not valid CSV, editing it as plain text: %v\n", err)
+		c.plain = true
+		_, err := dst.Write(raw)
+		return err
+	}
+
+	_, err = normalized.WriteTo(dst)
+	return err
+}
+
+// CopyOut copies data from reader to writer after validating the CSV. Then it closes the writer.
+// Nothing gets written when the CSV is invalid.
+func (c *CSVShovel) CopyOut(dst io.WriteCloser, src io.ReadCloser) error {
+	if c.plain {
+		return c.Shovel.CopyOut(dst, src)
+	}
+
+	comma := c.detected
+	if comma == 0 {
+		comma = c.getComma()
+	}
+
+	reader := csv.NewReader(src)
+	reader.FieldsPerRecord = -1
+	reader.Comma = comma
+	if c.Strict {
+		reader.FieldsPerRecord = 0 // Set by the first record
+	}
+
+	validated := &bytes.Buffer{}
+	writer := csv.NewWriter(validated)
+	writer.Comma = comma
+	writer.UseCRLF = c.crlf
+	if err := copyCSV(writer, reader); err != nil {
+		return err
+	}
+
+	return c.Shovel.CopyOut(dst, readCloser{Reader: validated, Closer: src})
+}
+
+// getComma returns the configured delimiter, without detection
+func (c *CSVShovel) getComma() rune {
+	switch {
+	case c.Comma != 0:
+		return c.Comma
+	case c.FallbackComma != 0:
+		return c.FallbackComma
+	default:
+		return ','
+	}
+}
+
+// detect picks the candidate delimiter occurring the most in the header line
+func (c *CSVShovel) detect(reader *bufio.Reader) rune {
+	// Peek returns what is available for short inputs, the error doesn't matter
+	head, _ := reader.Peek(4096)
+	if end := bytes.IndexByte(head, '\n'); end >= 0 {
+		head = head[:end]
+	}
+
+	best, bestCount := c.getComma(), 0
+	for _, delimiter := range csvDelimiters {
+		if count := bytes.Count(head, []byte(string(delimiter))); count > bestCount {
+			best, bestCount = delimiter, count
+		}
+	}
+	return best
+}
+
+// isCRLF checks does the header line end with CRLF
+func isCRLF(reader *bufio.Reader) bool {
+	head, _ := reader.Peek(4096)
+	end := bytes.IndexByte(head, '\n')
+	return end > 0 && head[end-1] == '\r'
+}
+
+func copyCSV(writer *csv.Writer, reader *csv.Reader) error {
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
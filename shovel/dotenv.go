@@ -0,0 +1,82 @@
+package shovel
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// dotenvLine matches a variable assignment, e.g. KEY=value or export KEY="value"
+var dotenvLine = regexp.MustCompile(`^\s*(export\s+)?[A-Za-z_][A-Za-z0-9_]*\s*=`)
+
+// A DotenvShovel validates .env files before writing them, so typos don't reach the destination.
+// It wraps another shovel, which handles the compression.
+type DotenvShovel struct {
+	Shovel Shovel
+}
+
+// CopyIn copies data from reader to writer as it is. Then it closes the reader.
+func (d DotenvShovel) CopyIn(dst io.WriteCloser, src io.ReadCloser) error {
+	return d.Shovel.CopyIn(dst, src)
+}
+
+// CopyOut copies data from reader to writer after validating every line is blank, a comment or KEY=VALUE.
+// Then it closes the writer. Nothing gets written when a line is malformed.
+func (d DotenvShovel) CopyOut(dst io.WriteCloser, src io.ReadCloser) error {
+	validated := &bytes.Buffer{}
+	if err := validateDotenv(io.TeeReader(src, validated)); err != nil {
+		src.Close()
+		return err
+	}
+
+	return d.Shovel.CopyOut(dst, readCloser{Reader: validated, Closer: src})
+}
+
+// validateDotenv reports all the malformed lines. Quoted values may span lines
+func validateDotenv(reader io.Reader) error {
+	malformed := []string{}
+	quote := ""
+
+	scanner := bufio.NewScanner(reader)
+	for number := 1; scanner.Scan(); number++ {
+		line := scanner.Text()
+		if quote != "" {
+			// Continuing a quoted value until its closing quote
+			if strings.Contains(line, quote) {
+				quote = ""
+			}
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		assignment := dotenvLine.FindString(line)
+		if assignment == "" {
+			malformed = append(malformed, fmt.Sprintf("line %d: %#v", number, line))
+			continue
+		}
+
+		value := strings.TrimSpace(line[len(assignment):])
+		for _, q := range []string{`"`, `'`} {
+			if strings.HasPrefix(value, q) && !strings.Contains(value[1:], q) {
+				quote = q
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if quote != "" {
+		malformed = append(malformed, fmt.Sprintf("unterminated %s quoted value", quote))
+	}
+	if len(malformed) > 0 {
+		return fmt.Errorf("Invalid .env, expected KEY=VALUE lines: %s", strings.Join(malformed, ", "))
+	}
+	return nil
+}
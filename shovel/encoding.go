@@ -0,0 +1,118 @@
+package shovel
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// Encodings supported by the EncodingShovel.
+const (
+	EncodingAuto    = "auto"
+	EncodingUTF8    = "utf-8"
+	EncodingUTF16LE = "utf-16le"
+	EncodingUTF16BE = "utf-16be"
+)
+
+var (
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+)
+
+// An EncodingShovel transcodes text to UTF-8 for editing and back to its original encoding.
+// It wraps another shovel, which handles the compression.
+type EncodingShovel struct {
+	Shovel   Shovel
+	Encoding string // One of the Encoding constants. Auto detects UTF-16 by its BOM
+
+	detected string
+	bom      bool
+}
+
+// CopyIn copies data from reader to writer while transcoding it to UTF-8. Then it closes the reader.
+func (e *EncodingShovel) CopyIn(dst io.WriteCloser, src io.ReadCloser) error {
+	switch e.Encoding {
+	case "", EncodingAuto, EncodingUTF8, EncodingUTF16LE, EncodingUTF16BE:
+	default:
+		src.Close()
+		return fmt.Errorf("Unsupported encoding: %#v", e.Encoding)
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		pipeWriter.CloseWithError(e.Shovel.CopyIn(pipeWriter, src))
+	}()
+	defer pipeReader.Close()
+
+	reader := bufio.NewReader(pipeReader)
+	e.detect(reader)
+
+	var decoded io.Reader = reader
+	if enc := e.getEncoding(); enc != nil {
+		decoded = transform.NewReader(reader, enc.NewDecoder())
+	}
+
+	_, err := io.Copy(dst, decoded)
+	return err
+}
+
+// CopyOut copies data from reader to writer while transcoding it back to the original encoding. Then it closes the writer.
+func (e *EncodingShovel) CopyOut(dst io.WriteCloser, src io.ReadCloser) error {
+	enc := e.getEncoding()
+	if enc == nil {
+		return e.Shovel.CopyOut(dst, src)
+	}
+
+	var encoded io.Reader = transform.NewReader(src, enc.NewEncoder())
+	if e.bom {
+		bom := bomUTF16LE
+		if e.detected == EncodingUTF16BE {
+			bom = bomUTF16BE
+		}
+		encoded = io.MultiReader(bytes.NewReader(bom), encoded)
+	}
+
+	return e.Shovel.CopyOut(dst, readCloser{Reader: encoded, Closer: src})
+}
+
+// detect picks the encoding and consumes the BOM, if there is one.
+func (e *EncodingShovel) detect(reader *bufio.Reader) {
+	// Peek returns fewer bytes for short inputs, which simply means no BOM
+	prefix, _ := reader.Peek(len(bomUTF16LE))
+
+	e.detected = e.Encoding
+	e.bom = false
+	switch {
+	case bytes.Equal(prefix, bomUTF16LE) && e.Encoding != EncodingUTF8 && e.Encoding != EncodingUTF16BE:
+		e.detected = EncodingUTF16LE
+		e.bom = true
+	case bytes.Equal(prefix, bomUTF16BE) && e.Encoding != EncodingUTF8 && e.Encoding != EncodingUTF16LE:
+		e.detected = EncodingUTF16BE
+		e.bom = true
+	}
+
+	if e.bom {
+		reader.Discard(len(prefix))
+	}
+}
+
+func (e *EncodingShovel) getEncoding() encoding.Encoding {
+	switch e.detected {
+	case EncodingUTF16LE:
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	case EncodingUTF16BE:
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+	}
+	return nil
+}
+
+// readCloser pairs a reader with the closer of the stream it reads from.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
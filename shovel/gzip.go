@@ -1,21 +1,49 @@
 package shovel
 
 import (
+	"bufio"
+	"bytes"
 	"compress/gzip"
+	"fmt"
 	"io"
+	"os"
 )
 
+var gzipMagic = []byte{0x1f, 0x8b}
+
 // A GzipShovel copies between uncompressed and compressed
-type GzipShovel struct{}
+type GzipShovel struct {
+	Lenient   bool // Copies content without the Gzip magic as plain, instead of failing
+	Truncated bool // Keeps what was uncompressed of a truncated stream, instead of failing
+
+	plain bool
+}
 
 // CopyIn copies data from reader to writer while uncompressing it with Gzip. Then it closes the reader.
-func (g GzipShovel) CopyIn(dst io.WriteCloser, src io.ReadCloser) error {
-	decompressedReader, err := gzip.NewReader(src)
+// Concatenated Gzip members are all uncompressed, one after another.
+func (g *GzipShovel) CopyIn(dst io.WriteCloser, src io.ReadCloser) error {
+	reader := bufio.NewReader(src)
+	if g.Lenient {
+		// Peek returns fewer bytes for short inputs, which aren't Gzip either
+		magic, _ := reader.Peek(len(gzipMagic))
+		g.plain = !bytes.Equal(magic, gzipMagic)
+	}
+	if g.plain {
+		fmt.Fprintln(os.Stderr, "Not Gzip compressed, reading it as plain text")
+		return PlainShovel{}.CopyIn(dst, readCloser{Reader: reader, Closer: src})
+	}
+
+	decompressedReader, err := gzip.NewReader(reader)
 	if err != nil {
 		return err
 	}
 
-	if _, err := io.Copy(dst, decompressedReader); err != nil {
+	written, err := io.Copy(dst, decompressedReader)
+	if err == io.ErrUnexpectedEOF && g.Truncated {
+		fmt.Fprintf(os.Stderr, "Gzip stream is truncated, only its first %d uncompressed bytes are shown\n", written)
+		return src.Close()
+	}
+	if err != nil {
 		return err
 	}
 
@@ -24,7 +52,12 @@ func (g GzipShovel) CopyIn(dst io.WriteCloser, src io.ReadCloser) error {
 }
 
 // CopyOut copies data from reader to writer while compressing it with Gzip. Then it closes the writer.
-func (g GzipShovel) CopyOut(dst io.WriteCloser, src io.ReadCloser) error {
+// It always writes a single Gzip member. Content read as plain is written back as plain.
+func (g *GzipShovel) CopyOut(dst io.WriteCloser, src io.ReadCloser) error {
+	if g.plain {
+		return PlainShovel{}.CopyOut(dst, src)
+	}
+
 	compressionWriter := gzip.NewWriter(dst)
 
 	if _, err := io.Copy(compressionWriter, src); err != nil {
@@ -35,7 +68,7 @@ func (g GzipShovel) CopyOut(dst io.WriteCloser, src io.ReadCloser) error {
 	return g.closeMany(toClose)
 }
 
-func (g GzipShovel) closeMany(closers []io.Closer) error {
+func (g *GzipShovel) closeMany(closers []io.Closer) error {
 	for _, closer := range closers {
 		if err := closer.Close(); err != nil {
 			return err
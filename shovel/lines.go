@@ -0,0 +1,91 @@
+package shovel
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// A LinesShovel presents a range of lines for editing, then merges them back into the rest of the content.
+// It wraps another shovel, which handles the compression. The lines around the range are kept in memory.
+type LinesShovel struct {
+	Shovel Shovel
+	First  int // First line of the range, counted from 1
+	Last   int // Last line of the range, included. Zero means the last line of the content
+
+	before []byte
+	after  []byte
+}
+
+// CopyIn copies the range of lines from reader to writer. Then it closes the reader.
+// It fails when the content has fewer lines than the range.
+func (l *LinesShovel) CopyIn(dst io.WriteCloser, src io.ReadCloser) error {
+	if l.First < 1 || (l.Last != 0 && l.Last < l.First) {
+		src.Close()
+		return fmt.Errorf("Invalid line range %s", l.describe())
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		pipeWriter.CloseWithError(l.Shovel.CopyIn(pipeWriter, src))
+	}()
+	defer pipeReader.Close()
+
+	before, after := &bytes.Buffer{}, &bytes.Buffer{}
+	reader := bufio.NewReader(pipeReader)
+	lines := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			lines++
+			switch {
+			case lines < l.First:
+				before.Write(line)
+			case l.Last == 0 || lines <= l.Last:
+				if _, err := dst.Write(line); err != nil {
+					return err
+				}
+			default:
+				after.Write(line)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if lines < l.First || lines < l.Last {
+		return fmt.Errorf("Line range %s is out of bounds, the content has %d lines", l.describe(), lines)
+	}
+	l.before, l.after = before.Bytes(), after.Bytes()
+	return nil
+}
+
+// CopyOut copies the lines before the range, the edited range from reader and the lines after it to writer.
+// Then it closes the writer.
+func (l *LinesShovel) CopyOut(dst io.WriteCloser, src io.ReadCloser) error {
+	edited, err := io.ReadAll(src)
+	if err != nil {
+		src.Close()
+		return err
+	}
+	// The following line mustn't get joined to the last edited one
+	if len(l.after) > 0 && len(edited) > 0 && edited[len(edited)-1] != '\n' {
+		edited = append(edited, '\n')
+	}
+
+	merged := io.MultiReader(bytes.NewReader(l.before), bytes.NewReader(edited), bytes.NewReader(l.after))
+	return l.Shovel.CopyOut(dst, readCloser{Reader: merged, Closer: src})
+}
+
+// describe formats the range as it is given, START:END
+func (l *LinesShovel) describe() string {
+	if l.Last == 0 {
+		return fmt.Sprintf("%d:", l.First)
+	}
+	return fmt.Sprintf("%d:%d", l.First, l.Last)
+}
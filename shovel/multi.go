@@ -2,30 +2,47 @@ package shovel
 
 import "io"
 
+// Compression is the format a MultiShovel compresses or uncompresses with
+type Compression int
+
+const (
+    NoCompression Compression = iota
+    GzipCompression
+    SnappyCompression
+)
+
 // A MultiShovel copies between reader and writer. Both uncompressed and compressed use cases are permitted
 type MultiShovel struct {
-    SourceCompressed      bool
-    DestinationCompressed bool
+    SourceCompression      Compression
+    DestinationCompression Compression
+    Lenient                bool // Reads Gzip sources which aren't Gzip compressed as plain, see GzipShovel
+    Truncated              bool // Reads the start of truncated Gzip sources, see GzipShovel
+
+    source Shovel
+}
+
+// CopyIn copies data from reader to writer while uncompressing it if flagged. Then it closes the reader.
+func (m *MultiShovel) CopyIn(dst io.WriteCloser, src io.ReadCloser) error {
+    m.source = m.getShovel(m.SourceCompression)
+    return m.source.CopyIn(dst, src)
 }
 
-// CopyIn copies data from reader to writer while uncompressing it with Gzip if flagged. Then it closes the reader.
-func (m MultiShovel) CopyIn(dst io.WriteCloser, src io.ReadCloser) error {
-    var shovel Shovel
-    if !m.SourceCompressed {
-        shovel = PlainShovel{}
-    } else {
-        shovel = GzipShovel{}
+// CopyOut copies data from reader to writer while compressing it if flagged. Then it closes the writer.
+func (m *MultiShovel) CopyOut(dst io.WriteCloser, src io.ReadCloser) error {
+    // The source's shovel knows whether it fell back to plain
+    if m.source != nil && m.DestinationCompression == m.SourceCompression {
+        return m.source.CopyOut(dst, src)
     }
-    return shovel.CopyIn(dst, src)
+    return m.getShovel(m.DestinationCompression).CopyOut(dst, src)
 }
 
-// CopyOut copies data from reader to writer while compressing it with Gzip it if flagged. Then it closes the writer.
-func (m MultiShovel) CopyOut(dst io.WriteCloser, src io.ReadCloser) error {
-    var shovel Shovel
-    if !m.DestinationCompressed {
-        shovel = PlainShovel{}
-    } else {
-        shovel = GzipShovel{}
+func (m *MultiShovel) getShovel(compression Compression) Shovel {
+    switch compression {
+    case GzipCompression:
+        return &GzipShovel{Lenient: m.Lenient, Truncated: m.Truncated}
+    case SnappyCompression:
+        return SnappyShovel{}
+    default:
+        return PlainShovel{}
     }
-    return shovel.CopyOut(dst, src)
 }
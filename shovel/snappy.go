@@ -0,0 +1,35 @@
+package shovel
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+// A SnappyShovel copies between uncompressed and Snappy framed
+type SnappyShovel struct{}
+
+// CopyIn copies data from reader to writer while uncompressing it with Snappy. Then it closes the reader.
+func (s SnappyShovel) CopyIn(dst io.WriteCloser, src io.ReadCloser) error {
+	decompressedReader := snappy.NewReader(src)
+
+	if _, err := io.Copy(dst, decompressedReader); err != nil {
+		return err
+	}
+
+	return src.Close()
+}
+
+// CopyOut copies data from reader to writer while compressing it with Snappy. Then it closes the writer.
+func (s SnappyShovel) CopyOut(dst io.WriteCloser, src io.ReadCloser) error {
+	compressionWriter := snappy.NewBufferedWriter(dst)
+
+	if _, err := io.Copy(compressionWriter, src); err != nil {
+		return err
+	}
+
+	if err := compressionWriter.Close(); err != nil {
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ErrReadOnly is returned when writing to a storage which can only be read.
+var ErrReadOnly = errors.New("Storage is read-only")
+
+// dataFileStorage serves the content inlined in a data: URI, e.g. data:text/csv;base64,YSxiCjEsMgo=
+type dataFileStorage struct {
+	reader *bytes.Reader
+}
+
+func getDataFileStorage(uri url.URL) (*dataFileStorage, error) {
+	data, err := decodeDataURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := new(dataFileStorage)
+	fs.reader = bytes.NewReader(data)
+	return fs, nil
+}
+
+// decodeDataURI returns the content of the data: URI, as described by RFC 2397
+func decodeDataURI(uri url.URL) ([]byte, error) {
+	raw := uri.Opaque
+	if uri.RawQuery != "" {
+		raw += "?" + uri.RawQuery
+	}
+	if uri.Fragment != "" {
+		raw += "#" + uri.EscapedFragment()
+	}
+
+	parts := strings.SplitN(raw, ",", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Invalid data URI, missing a comma: %#v", uri.String())
+	}
+	mediaType, data := parts[0], parts[1]
+
+	if strings.HasSuffix(mediaType, ";base64") {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid base64 in data URI: %w", err)
+		}
+		return decoded, nil
+	}
+
+	decoded, err := url.PathUnescape(data)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid escaping in data URI: %w", err)
+	}
+	return []byte(decoded), nil
+}
+
+func (d *dataFileStorage) Read(p []byte) (n int, err error) {
+	return d.reader.Read(p)
+}
+
+func (d *dataFileStorage) Write(p []byte) (n int, err error) {
+	return 0, d.CheckWritable()
+}
+
+func (d *dataFileStorage) CheckWritable() error {
+	return ErrReadOnly
+}
+
+func (d *dataFileStorage) Close() error {
+	return nil
+}
+
+func (d *dataFileStorage) Exists() (bool, error) {
+	return true, nil
+}
+
+func init() {
+	registerFileStorage(
+		registrationInfo{
+			storage:           func(uri url.URL, options Options) (FileStorage, error) { return getDataFileStorage(uri) },
+			prefixes:          []string{"data:"},
+			completionPrompts: []string{},
+		},
+	)
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataStorage(t *testing.T) {
+	cases := []struct {
+		uri      string
+		expected string
+		fails    bool
+	}{
+		{uri: "data:,a,b%0A1,2", expected: "a,b\n1,2"},
+		{uri: "data:text/csv;base64,YSxiCjEsMgo=", expected: "a,b\n1,2\n"},
+		{uri: "data:text/plain,a#b?c", expected: "a#b?c"},
+		{uri: "data:text/plain;charset=utf-8,%C5%BC%C3%B3%C5%82w", expected: "żółw"},
+		{uri: "data:", fails: true},
+		{uri: "data:text/csv;base64,not base64", fails: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.uri, func(t *testing.T) {
+			uri, err := ParseURI(tc.uri)
+			assert.NoError(t, err)
+
+			fs, err := GetFileStorage(uri, Options{})
+			if tc.fails {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			body, err := io.ReadAll(fs)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, string(body))
+			assert.NoError(t, fs.Close())
+		})
+	}
+}
+
+func TestDataStorageReadOnly(t *testing.T) {
+	fs, err := GetFileStorage(mustStrToURI(t, "data:,test"), Options{})
+	assert.NoError(t, err)
+
+	_, err = fs.Write([]byte("changed"))
+	assert.ErrorIs(t, err, ErrReadOnly)
+
+	assert.ErrorIs(t, fs.(WriteChecker).CheckWritable(), ErrReadOnly)
+}
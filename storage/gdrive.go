@@ -0,0 +1,442 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// gdriveAPI is where the Google Drive API is served
+const gdriveAPI = "https://www.googleapis.com"
+
+// gdriveFields are the file metadata fields requested
+const gdriveFields = "id,name,mimeType,modifiedTime"
+
+// gdriveExports pick the format Google-native documents are exported to, as they can't be downloaded
+var gdriveExports = map[string]string{
+	"application/vnd.google-apps.document":     "text/plain",
+	"application/vnd.google-apps.spreadsheet":  "text/csv",
+	"application/vnd.google-apps.presentation": "text/plain",
+	"application/vnd.google-apps.script":       "application/vnd.google-apps.script+json",
+}
+
+// errGDriveNotFound is returned when no file has the id or path
+var errGDriveNotFound = errors.New("Google Drive file not found")
+
+// gdriveFile is the metadata of a Drive file
+type gdriveFile struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	MimeType     string    `json:"mimeType"`
+	ModifiedTime time.Time `json:"modifiedTime"`
+}
+
+// isNative checks is the file a Google-native document, which is exported instead of downloaded
+func (f gdriveFile) isNative() bool {
+	return strings.HasPrefix(f.MimeType, "application/vnd.google-apps.")
+}
+
+// gdriveFileStorage reads a file by id, gdrive://<id>, or by path from My Drive, gdrive:///folder/name.
+// New content is uploaded as a new revision on Close. Google-native documents are exported, read-only.
+// Files named by a path which doesn't exist yet are created in their folder, which must exist.
+// The OAuth access token is taken from REMBLOB_GDRIVE_TOKEN, or the file named by REMBLOB_GDRIVE_TOKEN_FILE.
+type gdriveFileStorage struct {
+	uri             url.URL
+	api             string
+	client          *http.Client
+	ifModifiedSince time.Time
+	file            *gdriveFile
+	parentID        string // Folder to create the file in, when it doesn't exist yet
+	readBody        io.ReadCloser
+	writeBuff       *bytes.Buffer
+}
+
+func getGDriveFileStorage(uri url.URL, client *http.Client, api string) *gdriveFileStorage {
+	fs := new(gdriveFileStorage)
+	fs.uri = uri
+	fs.api = api
+	fs.client = client
+	return fs
+}
+
+// getGDriveToken reads the OAuth access token
+func getGDriveToken() (string, error) {
+	if token, ok := os.LookupEnv("REMBLOB_GDRIVE_TOKEN"); ok {
+		return token, nil
+	}
+	if tokenFile, ok := os.LookupEnv("REMBLOB_GDRIVE_TOKEN_FILE"); ok {
+		token, err := os.ReadFile(tokenFile)
+		return strings.TrimSpace(string(token)), err
+	}
+	return "", errors.New("Google Drive needs an OAuth access token in REMBLOB_GDRIVE_TOKEN or REMBLOB_GDRIVE_TOKEN_FILE")
+}
+
+func newGDriveRequest(method string, url string, body io.Reader) (*http.Request, error) {
+	token, err := getGDriveToken()
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	return request, nil
+}
+
+// gdriveError describes a request the API refused
+func gdriveError(response *http.Response) error {
+	return fmt.Errorf("Google Drive %s %s failed: %s", response.Request.Method, response.Request.URL.Path, response.Status)
+}
+
+// gdriveGet sends a GET request, decoding the JSON response into out
+func gdriveGet(client *http.Client, url string, out interface{}) error {
+	request, err := newGDriveRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return json.NewDecoder(response.Body).Decode(out)
+	case http.StatusNotFound:
+		return errGDriveNotFound
+	default:
+		return gdriveError(response)
+	}
+}
+
+// gdriveQuote quotes a string for a Drive search query
+func gdriveQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// gdriveSearch lists the files in the parent folder matching the name condition, e.g. "name = 'a.txt'". Empty matches all
+func gdriveSearch(client *http.Client, api string, parent string, nameCondition string) ([]gdriveFile, error) {
+	conditions := gdriveQuote(parent) + " in parents and trashed = false"
+	if nameCondition != "" {
+		conditions = nameCondition + " and " + conditions
+	}
+	query := url.Values{}
+	query.Set("q", conditions)
+	query.Set("fields", "files("+gdriveFields+")")
+
+	var result struct {
+		Files []gdriveFile `json:"files"`
+	}
+	err := gdriveGet(client, api+"/drive/v3/files?"+query.Encode(), &result)
+	return result.Files, err
+}
+
+// gdriveResolvePath finds a file by its path from the root of My Drive
+func gdriveResolvePath(client *http.Client, api string, filePath string) (gdriveFile, error) {
+	file := gdriveFile{ID: "root"}
+	for _, name := range strings.Split(strings.Trim(filePath, "/"), "/") {
+		files, err := gdriveSearch(client, api, file.ID, "name = "+gdriveQuote(name))
+		if err != nil {
+			return gdriveFile{}, err
+		}
+		if len(files) == 0 {
+			return gdriveFile{}, errGDriveNotFound
+		}
+		file = files[0]
+	}
+	return file, nil
+}
+
+// getFile fetches the metadata of the file, once
+func (g *gdriveFileStorage) getFile() (gdriveFile, error) {
+	if g.file != nil {
+		return *g.file, nil
+	}
+
+	var file gdriveFile
+	var err error
+	if g.uri.Host == "" {
+		file, err = gdriveResolvePath(g.client, g.api, uriPath(g.uri))
+	} else {
+		err = gdriveGet(g.client, g.api+"/drive/v3/files/"+url.PathEscape(g.uri.Host)+"?fields="+gdriveFields, &file)
+	}
+	if err != nil {
+		return gdriveFile{}, err
+	}
+
+	g.file = &file
+	return file, nil
+}
+
+func (g *gdriveFileStorage) Read(p []byte) (n int, err error) {
+	if g.readBody == nil {
+		file, err := g.getFile()
+		if err != nil {
+			return 0, err
+		}
+		if !g.ifModifiedSince.IsZero() && !file.ModifiedTime.After(g.ifModifiedSince) {
+			return 0, ErrNotModified
+		}
+
+		download := g.api + "/drive/v3/files/" + url.PathEscape(file.ID) + "?alt=media"
+		if file.isNative() {
+			export, ok := gdriveExports[file.MimeType]
+			if !ok {
+				return 0, fmt.Errorf("Google Drive file of type %s can't be exported", file.MimeType)
+			}
+			download = g.api + "/drive/v3/files/" + url.PathEscape(file.ID) + "/export?mimeType=" + url.QueryEscape(export)
+		}
+
+		request, err := newGDriveRequest(http.MethodGet, download, nil)
+		if err != nil {
+			return 0, err
+		}
+		response, err := g.client.Do(request)
+		if err != nil {
+			return 0, err
+		}
+		if response.StatusCode != http.StatusOK {
+			response.Body.Close()
+			return 0, gdriveError(response)
+		}
+		g.readBody = response.Body
+	}
+
+	return g.readBody.Read(p)
+}
+
+func (g *gdriveFileStorage) Write(p []byte) (n int, err error) {
+	if g.writeBuff == nil {
+		if err := g.CheckWritable(); err != nil {
+			return 0, err
+		}
+		g.writeBuff = &bytes.Buffer{}
+	}
+	return g.writeBuff.Write(p)
+}
+
+// CheckWritable refuses Google-native documents, and files which can't be found nor created.
+// A missing file named by path gets the folder to create it in resolved.
+func (g *gdriveFileStorage) CheckWritable() error {
+	file, err := g.getFile()
+	if errors.Is(err, errGDriveNotFound) && g.uri.Host == "" {
+		return g.findParent()
+	}
+	if err != nil {
+		return err
+	}
+	if file.isNative() {
+		return fmt.Errorf("%w: Google-native documents are only exported", ErrReadOnly)
+	}
+	return nil
+}
+
+// findParent resolves the folder of the file named by path, to create the file in
+func (g *gdriveFileStorage) findParent() error {
+	folderPath := path.Dir("/" + strings.Trim(uriPath(g.uri), "/"))
+	folder := gdriveFile{ID: "root"}
+	if folderPath != "/" {
+		var err error
+		folder, err = gdriveResolvePath(g.client, g.api, folderPath)
+		if errors.Is(err, errGDriveNotFound) {
+			return fmt.Errorf("%w: no folder %s to create the file in", errGDriveNotFound, folderPath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	g.parentID = folder.ID
+	return nil
+}
+
+// upload stores the written content as a new revision of the file, or as a new file
+func (g *gdriveFileStorage) upload() error {
+	if g.file == nil {
+		return g.create()
+	}
+
+	upload := g.api + "/upload/drive/v3/files/" + url.PathEscape(g.file.ID) + "?uploadType=media"
+	request, err := newGDriveRequest(http.MethodPatch, upload, bytes.NewReader(g.writeBuff.Bytes()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", g.file.MimeType)
+	response, err := g.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return gdriveError(response)
+	}
+	return nil
+}
+
+// create stores the written content as a new file in the parent folder, typed by its extension
+func (g *gdriveFileStorage) create() error {
+	name := path.Base(uriPath(g.uri))
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	metadata, err := json.Marshal(map[string]interface{}{"name": name, "parents": []string{g.parentID}})
+	if err != nil {
+		return err
+	}
+
+	// The metadata and the content go in one multipart/related request
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"application/json; charset=UTF-8", metadata},
+		{contentType, g.writeBuff.Bytes()},
+	} {
+		partWriter, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return err
+		}
+		if _, err := partWriter.Write(part.content); err != nil {
+			return err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	upload := g.api + "/upload/drive/v3/files?uploadType=multipart&fields=" + gdriveFields
+	request, err := newGDriveRequest(http.MethodPost, upload, body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "multipart/related; boundary="+writer.Boundary())
+	response, err := g.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return gdriveError(response)
+	}
+	var file gdriveFile
+	if err := json.NewDecoder(response.Body).Decode(&file); err != nil {
+		return err
+	}
+	g.file = &file
+	return nil
+}
+
+func (g *gdriveFileStorage) Close() error {
+	if g.readBody != nil {
+		if err := g.readBody.Close(); err != nil {
+			return err
+		}
+		g.readBody = nil
+	}
+
+	if g.writeBuff != nil {
+		if err := g.upload(); err != nil {
+			return err
+		}
+		g.writeBuff = nil
+	}
+	return nil
+}
+
+func (g *gdriveFileStorage) Exists() (bool, error) {
+	_, err := g.getFile()
+	if errors.Is(err, errGDriveNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetContentType returns the media type of the file, or of its export for Google-native documents.
+func (g *gdriveFileStorage) GetContentType() (string, error) {
+	file, err := g.getFile()
+	if err != nil {
+		return "", err
+	}
+	if export, ok := gdriveExports[file.MimeType]; ok {
+		return export, nil
+	}
+	return file.MimeType, nil
+}
+
+// gdriveFileStorageLister suggests the files of a folder, by path, which names start with the prefix's last part
+func gdriveFileStorageLister(prefix url.URL, client *http.Client, api string) []url.URL {
+	suggestions := []url.URL{}
+	if prefix.Host != "" {
+		return suggestions // File ids can't be completed
+	}
+
+	folderPath, namePrefix := path.Split(uriPath(prefix))
+	folder := gdriveFile{ID: "root"}
+	if strings.Trim(folderPath, "/") != "" {
+		var err error
+		if folder, err = gdriveResolvePath(client, api, folderPath); err != nil {
+			return suggestions
+		}
+	}
+
+	// Drive matches "contains" against the start of the name's words
+	nameCondition := ""
+	if namePrefix != "" {
+		nameCondition = "name contains " + gdriveQuote(namePrefix)
+	}
+	files, err := gdriveSearch(client, api, folder.ID, nameCondition)
+	if err != nil {
+		return suggestions
+	}
+
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name, namePrefix) {
+			continue
+		}
+		suggestions = append(suggestions, url.URL{Scheme: prefix.Scheme, Path: path.Join("/", folderPath, file.Name)})
+	}
+	return suggestions
+}
+
+// checkGDrive asks who the token belongs to, which needs the API to be reachable and the token to be valid
+func checkGDrive(client *http.Client, api string) error {
+	var about struct{}
+	return gdriveGet(client, api+"/drive/v3/about?fields=user", &about)
+}
+
+func init() {
+	registerFileStorage(
+		registrationInfo{
+			storage: func(uri url.URL, options Options) (FileStorage, error) {
+				fs := getGDriveFileStorage(uri, http.DefaultClient, gdriveAPI)
+				fs.ifModifiedSince = options.IfModifiedSince
+				return fs, nil
+			},
+			lister: func(prefix url.URL) []url.URL {
+				return gdriveFileStorageLister(prefix, http.DefaultClient, gdriveAPI)
+			},
+			check: func(options Options) error {
+				return checkGDrive(&http.Client{Timeout: checkTimeout}, gdriveAPI)
+			},
+			prefixes:          []string{"gdrive://"},
+			completionPrompts: []string{},
+		},
+	)
+}
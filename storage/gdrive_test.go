@@ -0,0 +1,322 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockGDriveFile is a file kept in memory by the mock, with its metadata
+type mockGDriveFile struct {
+	gdriveFile
+	parent string
+	body   string
+}
+
+// mockGDriveServer answers the few Drive API requests the storage makes, for files keyed by id.
+type mockGDriveServer struct {
+	sync.Mutex
+	files map[string]*mockGDriveFile
+}
+
+var (
+	gdriveNameEquals   = regexp.MustCompile(`name = '([^']*)'`)
+	gdriveNameContains = regexp.MustCompile(`name contains '([^']*)'`)
+	gdriveParent       = regexp.MustCompile(`'([^']*)' in parents`)
+)
+
+func (m *mockGDriveServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/about":
+		io.WriteString(w, `{"user": {"displayName": "someone"}}`)
+	case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+		q := r.URL.Query().Get("q")
+		parent := gdriveParent.FindStringSubmatch(q)[1]
+		found := []gdriveFile{}
+		for _, file := range m.files {
+			equals := gdriveNameEquals.FindStringSubmatch(q)
+			contains := gdriveNameContains.FindStringSubmatch(q)
+			if file.parent != parent ||
+				(equals != nil && file.Name != equals[1]) ||
+				(contains != nil && !strings.HasPrefix(file.Name, contains[1])) {
+				continue
+			}
+			found = append(found, file.gdriveFile)
+		}
+		sort.Slice(found, func(i, j int) bool { return found[i].Name < found[j].Name })
+		json.NewEncoder(w).Encode(map[string][]gdriveFile{"files": found})
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/export"):
+		file, ok := m.files[strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/drive/v3/files/"), "/export")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, r.URL.Query().Get("mimeType")+": "+file.body)
+	case r.Method == http.MethodGet:
+		file, ok := m.files[strings.TrimPrefix(r.URL.Path, "/drive/v3/files/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("alt") == "media" {
+			io.WriteString(w, file.body)
+			return
+		}
+		json.NewEncoder(w).Encode(file.gdriveFile)
+	case r.Method == http.MethodPatch:
+		file, ok := m.files[strings.TrimPrefix(r.URL.Path, "/upload/drive/v3/files/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		content, _ := io.ReadAll(r.Body)
+		file.body = string(content)
+		json.NewEncoder(w).Encode(file.gdriveFile)
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/drive/v3/files":
+		file, err := mockGDriveCreate(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		file.ID = fmt.Sprintf("new%d", len(m.files))
+		m.files[file.ID] = file
+		json.NewEncoder(w).Encode(file.gdriveFile)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// mockGDriveCreate reads the file of a multipart upload: its metadata, then its content
+func mockGDriveCreate(r *http.Request) (*mockGDriveFile, error) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	reader := multipart.NewReader(r.Body, params["boundary"])
+
+	var metadata struct {
+		Name    string   `json:"name"`
+		Parents []string `json:"parents"`
+	}
+	part, err := reader.NextPart()
+	if err != nil {
+		return nil, err
+	}
+	if err := json.NewDecoder(part).Decode(&metadata); err != nil {
+		return nil, err
+	}
+	part, err = reader.NextPart()
+	if err != nil {
+		return nil, err
+	}
+	content, err := io.ReadAll(part)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &mockGDriveFile{parent: metadata.Parents[0], body: string(content)}
+	file.Name = metadata.Name
+	file.MimeType = part.Header.Get("Content-Type")
+	return file, nil
+}
+
+func newMockGDriveServer(t *testing.T) (*mockGDriveServer, string) {
+	t.Setenv("REMBLOB_GDRIVE_TOKEN", "token")
+
+	modified := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	mock := &mockGDriveServer{files: map[string]*mockGDriveFile{
+		"dir1": {gdriveFile: gdriveFile{ID: "dir1", Name: "configs", MimeType: "application/vnd.google-apps.folder"}, parent: "root"},
+		"file1": {
+			gdriveFile: gdriveFile{ID: "file1", Name: "app.yaml", MimeType: "application/yaml", ModifiedTime: modified},
+			parent:     "dir1",
+			body:       "test",
+		},
+		"file2": {
+			gdriveFile: gdriveFile{ID: "file2", Name: "app-old.yaml", MimeType: "application/yaml", ModifiedTime: modified},
+			parent:     "dir1",
+		},
+		"sheet1": {
+			gdriveFile: gdriveFile{ID: "sheet1", Name: "Budget", MimeType: "application/vnd.google-apps.spreadsheet", ModifiedTime: modified},
+			parent:     "root",
+			body:       "a,b",
+		},
+	}}
+	server := httptest.NewServer(mock)
+	t.Cleanup(server.Close)
+
+	return mock, server.URL
+}
+
+func TestGDriveStorageReadWrite(t *testing.T) {
+	for _, uri := range []string{"gdrive://file1", "gdrive:///configs/app.yaml"} {
+		t.Run(uri, func(t *testing.T) {
+			mock, api := newMockGDriveServer(t)
+
+			fs := getGDriveFileStorage(mustStrToURI(t, uri), http.DefaultClient, api)
+			assert.Equal(t, "test", mustReadAll(t, fs))
+			assert.NoError(t, fs.Close())
+
+			contentType, err := fs.GetContentType()
+			assert.NoError(t, err)
+			assert.Equal(t, "application/yaml", contentType)
+
+			_, err = fs.Write([]byte("changed " + uri))
+			assert.NoError(t, err)
+			assert.NoError(t, fs.Close())
+			assert.Equal(t, "changed "+uri, mock.files["file1"].body)
+		})
+	}
+}
+
+func TestGDriveStorageNative(t *testing.T) {
+	_, api := newMockGDriveServer(t)
+
+	fs := getGDriveFileStorage(mustStrToURI(t, "gdrive:///Budget"), http.DefaultClient, api)
+	assert.Equal(t, "text/csv: a,b", mustReadAll(t, fs))
+
+	contentType, err := fs.GetContentType()
+	assert.NoError(t, err)
+	assert.Equal(t, "text/csv", contentType)
+
+	_, err = fs.Write([]byte("c,d"))
+	assert.ErrorIs(t, err, ErrReadOnly)
+}
+
+func TestGDriveStorageCreate(t *testing.T) {
+	mock, api := newMockGDriveServer(t)
+
+	fs := getGDriveFileStorage(mustStrToURI(t, "gdrive:///configs/new.json"), http.DefaultClient, api)
+	assert.NoError(t, fs.CheckWritable())
+	_, err := fs.Write([]byte("{}"))
+	assert.NoError(t, err)
+	assert.NoError(t, fs.Close())
+
+	created := getGDriveFileStorage(mustStrToURI(t, "gdrive:///configs/new.json"), http.DefaultClient, api)
+	assert.Equal(t, "{}", mustReadAll(t, created))
+	assert.NoError(t, created.Close())
+	file, err := created.getFile()
+	assert.NoError(t, err)
+	assert.Equal(t, "application/json", file.MimeType)
+	assert.Equal(t, "dir1", mock.files[file.ID].parent)
+
+	// Also at the root of My Drive
+	fs = getGDriveFileStorage(mustStrToURI(t, "gdrive:///top.txt"), http.DefaultClient, api)
+	_, err = fs.Write([]byte("top"))
+	assert.NoError(t, err)
+	assert.NoError(t, fs.Close())
+	assert.Equal(t, "root", mock.files[fs.file.ID].parent)
+}
+
+func TestGDriveStorageNotWritable(t *testing.T) {
+	_, api := newMockGDriveServer(t)
+
+	cases := []struct {
+		uri      string
+		expected error
+	}{
+		{uri: "gdrive:///missing/app.yaml", expected: errGDriveNotFound}, // No folder to create it in
+		{uri: "gdrive://missing", expected: errGDriveNotFound},           // Ids can't be created
+		{uri: "gdrive:///Budget", expected: ErrReadOnly},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.uri, func(t *testing.T) {
+			fs := getGDriveFileStorage(mustStrToURI(t, tc.uri), http.DefaultClient, api)
+			assert.ErrorIs(t, fs.CheckWritable(), tc.expected)
+		})
+	}
+}
+
+func TestGDriveStorageExists(t *testing.T) {
+	_, api := newMockGDriveServer(t)
+
+	cases := []struct {
+		uri      string
+		expected bool
+	}{
+		{uri: "gdrive://file1", expected: true},
+		{uri: "gdrive:///configs/app.yaml", expected: true},
+		{uri: "gdrive://missing", expected: false},
+		{uri: "gdrive:///configs/missing.yaml", expected: false},
+		{uri: "gdrive:///missing/app.yaml", expected: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.uri, func(t *testing.T) {
+			exists, err := getGDriveFileStorage(mustStrToURI(t, tc.uri), http.DefaultClient, api).Exists()
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, exists)
+		})
+	}
+}
+
+func TestGDriveStorageToken(t *testing.T) {
+	_, api := newMockGDriveServer(t)
+	t.Setenv("REMBLOB_GDRIVE_TOKEN", "wrong")
+
+	_, err := io.ReadAll(getGDriveFileStorage(mustStrToURI(t, "gdrive://file1"), http.DefaultClient, api))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "401")
+}
+
+func TestGDriveStorageIfModifiedSince(t *testing.T) {
+	mock, api := newMockGDriveServer(t)
+
+	fs := getGDriveFileStorage(mustStrToURI(t, "gdrive://file1"), http.DefaultClient, api)
+	fs.ifModifiedSince = mock.files["file1"].ModifiedTime.Add(time.Hour)
+	_, err := io.ReadAll(fs)
+
+	assert.ErrorIs(t, err, ErrNotModified)
+}
+
+func TestGDriveStorageSuggestions(t *testing.T) {
+	_, api := newMockGDriveServer(t)
+
+	cases := []struct {
+		prefix   string
+		expected []string
+	}{
+		{prefix: "gdrive:///", expected: []string{"gdrive:///Budget", "gdrive:///configs"}},
+		{prefix: "gdrive:///con", expected: []string{"gdrive:///configs"}},
+		{prefix: "gdrive:///configs/", expected: []string{"gdrive:///configs/app-old.yaml", "gdrive:///configs/app.yaml"}},
+		{prefix: "gdrive:///configs/app.", expected: []string{"gdrive:///configs/app.yaml"}},
+		{prefix: "gdrive://file1", expected: []string{}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.prefix, func(t *testing.T) {
+			suggestions := gdriveFileStorageLister(mustStrToURI(t, tc.prefix), http.DefaultClient, api)
+			assert.Equal(t, tc.expected, urisToPaths(suggestions))
+		})
+	}
+}
+
+func TestGDriveCheck(t *testing.T) {
+	_, api := newMockGDriveServer(t)
+	assert.NoError(t, checkGDrive(http.DefaultClient, api))
+
+	t.Setenv("REMBLOB_GDRIVE_TOKEN", "wrong")
+	err := checkGDrive(http.DefaultClient, api)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "401")
+}
@@ -1,10 +1,13 @@
 package storage
 
 import (
+    "errors"
     "fmt"
     "log"
     "net/url"
     "sort"
+    "strings"
+    "time"
 )
 
 type FileStorage interface {
@@ -13,12 +16,138 @@ type FileStorage interface {
     Close() error
 }
 
-type fileStorageBuilder func(url.URL) FileStorage
+// ErrNotModified is returned when reading a file not modified since Options.IfModifiedSince.
+var ErrNotModified = errors.New("Not modified")
+
+// ErrSymlink is returned when a local file is a symbolic link and Options.NoFollowSymlinks is set.
+var ErrSymlink = errors.New("Refusing to follow a symbolic link")
+
+// An ExistenceChecker tells whether the file exists, without reading it.
+type ExistenceChecker interface {
+    Exists() (bool, error)
+}
+
+// A WriteChecker tells whether the file can be written at all, before anything is read or edited.
+type WriteChecker interface {
+    // CheckWritable returns ErrReadOnly, wrapped with the reason, when the file can't be written.
+    CheckWritable() error
+}
+
+// A ContentLengthCapable storage knows the size of the file read, to detect truncated reads.
+type ContentLengthCapable interface {
+    // GetContentLength returns the expected size of the file read, and whether it is known.
+    GetContentLength() (int64, bool)
+}
+
+// A MetadataUpdater changes the metadata of the file in place, leaving its content intact.
+type MetadataUpdater interface {
+    // UpdateMetadata merges the metadata in, empty values remove keys. An empty content type keeps the current one.
+    UpdateMetadata(contentType string, metadata map[string]string) error
+}
+
+// A ContentTypeCapable storage tells the content type of the file without reading it.
+type ContentTypeCapable interface {
+    GetContentType() (string, error)
+}
+
+// A VersionCapable storage tells the version of the file, e.g. its ETag.
+type VersionCapable interface {
+    // GetVersion returns the current version, without reading the file.
+    GetVersion() (string, error)
+    // GetReadVersion returns the version of the file read, as told when reading started, and whether it is known.
+    GetReadVersion() (string, bool)
+}
+
+// A MetadataCapable storage keeps user defined metadata along with the file.
+type MetadataCapable interface {
+    // GetMetadata returns the metadata of the file read.
+    GetMetadata() map[string]string
+    // SetMetadata sets the metadata of the file to be written.
+    SetMetadata(metadata map[string]string)
+}
+
+// A HeadersCapable storage keeps HTTP headers along with the file, e.g. Content-Disposition.
+type HeadersCapable interface {
+    // GetHeaders returns the headers of the file read, by their canonical names.
+    GetHeaders() map[string]string
+    // SetHeaders sets the headers of the file to be written.
+    SetHeaders(headers map[string]string)
+}
+
+// An ObjectVersion is one of the versions kept of a file, e.g. in S3 buckets with versioning enabled.
+type ObjectVersion struct {
+    ID           string    `json:"id"`
+    LastModified time.Time `json:"last_modified"`
+    Size         int64     `json:"size"`
+    Latest       bool      `json:"latest"`
+    DeleteMarker bool      `json:"delete_marker"` // The file was deleted, the version has no content
+}
+
+// A VersionLister lists the versions kept of the file.
+type VersionLister interface {
+    // ListVersions returns the versions, the most recent first.
+    ListVersions() ([]ObjectVersion, error)
+}
+
+// Options carries the per-invocation settings of the storage backends.
+type Options struct {
+    // S3Provider selects an endpoint preset for S3-compatible services.
+    S3Provider string
+    // CredentialsFile replaces the default shared AWS credentials file.
+    CredentialsFile string
+    // IfModifiedSince makes reading fail with ErrNotModified for files not modified after it.
+    IfModifiedSince time.Time
+    // VersionID reads this version of an S3 object instead of the latest. Empty reads the latest.
+    VersionID string
+    // MakeDirs creates missing parent directories of local files being written.
+    MakeDirs bool
+    // NoFollowSymlinks refuses to read or write local files which are symbolic links.
+    NoFollowSymlinks bool
+    // PreserveMtime restores the modification time of a local file after overwriting it.
+    PreserveMtime bool
+    // BackupSuffix keeps a copy of a local file being overwritten, with the suffix added to its name. Empty disables it.
+    BackupSuffix string
+    // Region of S3 buckets, unless AWS_REGION is set.
+    Region string
+    // StorageClass of S3 objects written. Empty leaves it to the bucket's default.
+    StorageClass string
+    // Schemes hold defaults by scheme, for the options left empty. Local files use the "file" scheme.
+    Schemes map[string]SchemeDefaults
+}
+
+// SchemeDefaults are the options applied to a single scheme, e.g. only to s3://.
+type SchemeDefaults struct {
+    Region       string `yaml:"region"`
+    StorageClass string `yaml:"storage-class"`
+}
+
+// forScheme fills the options left empty with the defaults of the scheme.
+func (o Options) forScheme(scheme string) Options {
+    if scheme == "" {
+        scheme = "file"
+    }
+    defaults := o.Schemes[scheme]
+    if o.Region == "" {
+        o.Region = defaults.Region
+    }
+    if o.StorageClass == "" {
+        o.StorageClass = defaults.StorageClass
+    }
+    return o
+}
+
+type fileStorageBuilder func(url.URL, Options) (FileStorage, error)
 type FileLister func(url.URL) []url.URL
 
 type registrationInfo struct {
     storage           fileStorageBuilder
     lister            FileLister
+    // parser handles locations url.Parse can't, it reports whether it did. Optional
+    parser            func(raw string) (url.URL, bool)
+    // resolver replaces placeholders in the uri, e.g. picking an actual object. Optional
+    resolver          func(url.URL, Options) (url.URL, error)
+    // check tells whether the backend is usable, e.g. its credentials resolve. Optional
+    check             func(Options) error
     prefixes          []string
     completionPrompts []string
 }
@@ -44,14 +173,111 @@ func emptyFileLister(prefix url.URL) []url.URL {
     return []url.URL{}
 }
 
-func GetFileStorage(uri url.URL) (FileStorage, error) {
+func GetFileStorage(uri url.URL, options Options) (FileStorage, error) {
     if info, ok := fileStorageRegister[uri.Scheme]; ok {
-        return info.storage(uri), nil
+        return info.storage(uri, options.forScheme(uri.Scheme))
     }
 
     return nil, fmt.Errorf("Can not handle this uri: %#v", uri.String())
 }
 
+// ParseURI parses a location given by the user. Unless it has a registered scheme, a location
+// without "://" is a local path, e.g. a Windows path like c:\data or a file name with a colon.
+func ParseURI(raw string) (url.URL, error) {
+    if scheme := strings.SplitN(raw, "://", 2); len(scheme) == 2 {
+        if info, ok := fileStorageRegister[scheme[0]]; ok && info.parser != nil {
+            if uri, ok := info.parser(raw); ok {
+                return uri, nil
+            }
+        }
+    }
+
+    uri, err := url.Parse(raw)
+    if err == nil {
+        if _, ok := fileStorageRegister[uri.Scheme]; ok {
+            return *uri, nil
+        }
+    }
+    if !strings.Contains(raw, "://") {
+        return url.URL{Path: raw}, nil
+    }
+    if err != nil {
+        return url.URL{}, err
+    }
+    return *uri, nil
+}
+
+// uriPath is the path of the uri, keeping "?" and "#" as a part of it. File names and
+// object keys may contain them, but url.Parse splits them off as a query and a fragment.
+func uriPath(uri url.URL) string {
+    uriPath := uri.Path
+    if uri.RawQuery != "" || uri.ForceQuery {
+        query, err := url.PathUnescape(uri.RawQuery)
+        if err != nil {
+            query = uri.RawQuery
+        }
+        uriPath += "?" + query
+    }
+    if uri.Fragment != "" {
+        uriPath += "#" + uri.Fragment
+    }
+    return uriPath
+}
+
+// ResolveURI replaces the placeholders a storage supports, e.g. s3://bucket/prefix/@latest
+// with the latest object under the prefix. Other uris are returned as they are.
+func ResolveURI(uri url.URL, options Options) (url.URL, error) {
+    if info, ok := fileStorageRegister[uri.Scheme]; ok && info.resolver != nil {
+        return info.resolver(uri, options)
+    }
+    return uri, nil
+}
+
+// checkTimeout limits how long a backend check may wait for a service
+const checkTimeout = 10 * time.Second
+
+// A BackendStatus tells whether the backend of a prefix is usable.
+type BackendStatus struct {
+    Prefix string `json:"prefix"`
+    OK     bool   `json:"ok"`
+    Error  string `json:"error,omitempty"`
+}
+
+// CheckBackends checks all the registered backends, ordered by their prefixes. Backends without a check are usable.
+func CheckBackends(options Options) []BackendStatus {
+    schemes := []string{}
+    for scheme := range fileStorageRegister {
+        if scheme != "" {
+            schemes = append(schemes, scheme)
+        }
+    }
+    sort.Strings(schemes)
+
+    statuses := []BackendStatus{}
+    for _, scheme := range schemes {
+        info := fileStorageRegister[scheme]
+        status := BackendStatus{Prefix: getSchemePrefix(info, scheme), OK: true}
+        if info.check != nil {
+            if err := info.check(options.forScheme(scheme)); err != nil {
+                status.OK = false
+                status.Error = err.Error()
+            }
+        }
+        statuses = append(statuses, status)
+    }
+    return statuses
+}
+
+// getSchemePrefix is the registered prefix of the scheme, e.g. "s3://" or "data:"
+func getSchemePrefix(info registrationInfo, scheme string) string {
+    for _, prefix := range info.prefixes {
+        if strings.HasPrefix(prefix, scheme+":") {
+            return prefix
+        }
+    }
+    return scheme + "://"
+}
+
 func GetFileListerPrefixes() []string {
     uniquePrefixes := map[string]bool{}
     for _, info := range fileStorageRegister {
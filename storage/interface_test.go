@@ -1,6 +1,10 @@
 package storage_test
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
 	"techiecaro/remblob/storage"
 	"testing"
 
@@ -10,7 +14,73 @@ import (
 func TestGetFileListerPrefixes(t *testing.T) {
 	prefixes := storage.GetFileListerPrefixes()
 
-	expected := []string{"./", "file://", "s3://"}
+	expected := []string{"./", "data:", "dav://", "davs://", "file://", "gdrive://", "local-s3://", "r2://", "s3://"}
 
 	assert.Equal(t, expected, prefixes, "Invalid prefixes")
 }
+
+func TestCheckBackends(t *testing.T) {
+	// An S3 endpoint which doesn't let anyone list the buckets
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`))
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ENDPOINT", server.URL)
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	// No Google Drive token, restored afterwards
+	for _, name := range []string{"REMBLOB_GDRIVE_TOKEN", "REMBLOB_GDRIVE_TOKEN_FILE"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+
+	statuses := storage.CheckBackends(storage.Options{})
+
+	expected := []storage.BackendStatus{
+		{Prefix: "data:", OK: true},
+		{Prefix: "dav://", OK: true},
+		{Prefix: "davs://", OK: true},
+		{Prefix: "file://", OK: true},
+		{Prefix: "gdrive://", OK: false, Error: "Google Drive needs an OAuth access token in REMBLOB_GDRIVE_TOKEN or REMBLOB_GDRIVE_TOKEN_FILE"},
+		{Prefix: "local-s3://", OK: true},
+		{Prefix: "r2://", OK: true},
+		{Prefix: "s3://", OK: true},
+	}
+	assert.Equal(t, expected, statuses)
+}
+
+func TestParseURI(t *testing.T) {
+	cases := []struct {
+		raw      string
+		expected url.URL
+		fails    bool
+	}{
+		{raw: "data/blob.json", expected: url.URL{Path: "data/blob.json"}},
+		{raw: "file:///data/blob.json", expected: url.URL{Scheme: "file", Path: "/data/blob.json"}},
+		{raw: "file:///data/blob.json?x=1#top", expected: url.URL{Scheme: "file", Path: "/data/blob.json"}},
+		{raw: "file://with%20space.txt", expected: url.URL{Scheme: "file", Host: "with space.txt"}},
+		{raw: "file://data/a%3Fb.json", expected: url.URL{Scheme: "file", Host: "data", Path: "/a?b.json"}},
+		{raw: "s3://bucket/blob.json", expected: url.URL{Scheme: "s3", Host: "bucket", Path: "/blob.json"}},
+		{raw: `c:\data\blob.json`, expected: url.URL{Path: `c:\data\blob.json`}},
+		{raw: "C:/data/blob.json", expected: url.URL{Path: "C:/data/blob.json"}},
+		{raw: "notes:2021.txt", expected: url.URL{Path: "notes:2021.txt"}},
+		{raw: "10:30.txt", expected: url.URL{Path: "10:30.txt"}},
+		{raw: "dir/10:30.txt", expected: url.URL{Path: "dir/10:30.txt"}},
+		{raw: "s4://bucket/blob.json", expected: url.URL{Scheme: "s4", Host: "bucket", Path: "/blob.json"}},
+		{raw: "s3://bucket:x/blob.json", fails: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.raw, func(t *testing.T) {
+			uri, err := storage.ParseURI(tc.raw)
+			if tc.fails {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, uri)
+		})
+	}
+}
@@ -1,40 +1,126 @@
 package storage
 
 import (
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/url"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 type localFileStorage struct {
-	uri       string
-	localFile *os.File
+	uri             string
+	localFile       *os.File
+	readLength      *int64
+	readVersion     string
+	ifModifiedSince time.Time
+	makeDirs        bool
+	noFollowLinks   bool
+	backupSuffix    string
+	preserveMtime   bool
+	originalMtime   *time.Time // Restored on Close, when preserving it
 }
 
-func getLocalFileStorage(uri url.URL) *localFileStorage {
+func getLocalFileStorage(uri url.URL, options Options) *localFileStorage {
 	fs := new(localFileStorage)
-	fs.uri = uriToPath(uri)
+	fs.uri = expandPath(uriToPath(uri))
 	fs.localFile = nil
+	fs.ifModifiedSince = options.IfModifiedSince
+	fs.makeDirs = options.MakeDirs
+	fs.noFollowLinks = options.NoFollowSymlinks
+	fs.backupSuffix = options.BackupSuffix
+	fs.preserveMtime = options.PreserveMtime
 	return fs
 }
 
 func (l *localFileStorage) Read(p []byte) (n int, err error) {
 	if l.localFile == nil {
+		if err := l.checkSymlink(); err != nil {
+			return 0, err
+		}
 		file, err := os.OpenFile(l.uri, os.O_RDONLY, 0755)
 		if err != nil {
 			return 0, err
 		}
+		if err := l.checkModified(file); err != nil {
+			file.Close()
+			return 0, err
+		}
+		if stat, err := file.Stat(); err == nil && stat.Mode().IsRegular() {
+			size := stat.Size()
+			l.readLength = &size
+			l.readVersion = getLocalVersion(stat)
+		}
 		l.localFile = file
 	}
 
 	return l.localFile.Read(p)
 }
 
+func (l *localFileStorage) checkModified(file *os.File) error {
+	if l.ifModifiedSince.IsZero() {
+		return nil
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if !stat.ModTime().After(l.ifModifiedSince) {
+		return ErrNotModified
+	}
+	return nil
+}
+
+// GetContentLength returns the size of the file read. Unknown for pipes and devices.
+func (l *localFileStorage) GetContentLength() (int64, bool) {
+	if l.readLength == nil {
+		return 0, false
+	}
+	return *l.readLength, true
+}
+
+// checkSymlink refuses symbolic links when asked to. Otherwise they are followed,
+// so writing rewrites the link target in place and the link itself is preserved.
+func (l *localFileStorage) checkSymlink() error {
+	if !l.noFollowLinks {
+		return nil
+	}
+
+	stat, err := os.Lstat(l.uri)
+	if err != nil {
+		// Missing files are reported by opening them
+		return nil
+	}
+	if stat.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("%w: %s", ErrSymlink, l.uri)
+	}
+	return nil
+}
+
 func (l *localFileStorage) Write(p []byte) (n int, err error) {
 	if l.localFile == nil {
+		if err := l.checkSymlink(); err != nil {
+			return 0, err
+		}
+		if l.makeDirs {
+			if err := os.MkdirAll(filepath.Dir(l.uri), 0755); err != nil {
+				return 0, err
+			}
+		}
+		if err := l.backup(); err != nil {
+			return 0, err
+		}
+		if l.preserveMtime {
+			// New files have no modification time to keep
+			if stat, err := os.Stat(l.uri); err == nil {
+				mtime := stat.ModTime()
+				l.originalMtime = &mtime
+			}
+		}
 		file, err := os.OpenFile(l.uri, os.O_RDWR|os.O_CREATE, 0755)
 		if err != nil {
 			return 0, err
@@ -45,6 +131,36 @@ func (l *localFileStorage) Write(p []byte) (n int, err error) {
 	return l.localFile.Write(p)
 }
 
+// backup copies the file about to be overwritten, replacing any previous backup
+func (l *localFileStorage) backup() error {
+	if l.backupSuffix == "" {
+		return nil
+	}
+
+	original, err := os.Open(l.uri)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer original.Close()
+
+	stat, err := original.Stat()
+	if err != nil {
+		return err
+	}
+	backup, err := os.OpenFile(l.uri+l.backupSuffix, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(backup, original); err != nil {
+		backup.Close()
+		return err
+	}
+	return backup.Close()
+}
+
 func (l *localFileStorage) Close() error {
 	if l.localFile == nil {
 		return nil
@@ -54,20 +170,74 @@ func (l *localFileStorage) Close() error {
 	if err != nil {
 		return err
 	}
-
 	l.localFile = nil
+
+	if l.originalMtime != nil {
+		mtime := *l.originalMtime
+		l.originalMtime = nil
+		return os.Chtimes(l.uri, time.Now(), mtime)
+	}
 	return nil
 }
 
+func (l *localFileStorage) Exists() (bool, error) {
+	_, err := os.Stat(l.uri)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetVersion tells apart contents of the file by their size and modification time.
+func (l *localFileStorage) GetVersion() (string, error) {
+	stat, err := os.Stat(l.uri)
+	if err != nil {
+		return "", err
+	}
+	return getLocalVersion(stat), nil
+}
+
+// GetReadVersion returns the version of the file read, as it was when opened.
+func (l *localFileStorage) GetReadVersion() (string, bool) {
+	return l.readVersion, l.readVersion != ""
+}
+
+func getLocalVersion(stat os.FileInfo) string {
+	return fmt.Sprintf("%d-%d", stat.Size(), stat.ModTime().UnixNano())
+}
+
+// uriToPath is the local path of the uri, using the separator of the OS.
+// The query and fragment of file:// URLs are ignored, bare paths are taken literally.
 func uriToPath(uri url.URL) string {
-	strURI := uri.Path
+	localPath := uriPath(uri)
+	if uri.Scheme == "file" {
+		localPath = uri.Path
+	}
+	strURI := filepath.FromSlash(localPath)
 	if uri.Host != "" {
-		strURI = path.Join(uri.Host, uri.Path)
+		strURI = filepath.Join(uri.Host, strURI)
 	}
 
 	return strURI
 }
 
+// expandPath expands a leading ~ to the home directory, and environment variables, e.g. $HOME/out.json.
+// Variables which aren't set are kept as they are, as they may be part of a file name.
+func expandPath(localPath string) string {
+	if localPath == "~" || strings.HasPrefix(localPath, "~/") || strings.HasPrefix(localPath, "~"+string(filepath.Separator)) {
+		if home, err := os.UserHomeDir(); err == nil {
+			localPath = home + localPath[1:]
+		}
+	}
+
+	return os.Expand(localPath, func(name string) string {
+		if value, ok := os.LookupEnv(name); ok {
+			return value
+		}
+		return "$" + name
+	})
+}
+
 func isDir(prefix string) bool {
 	stat, err := os.Stat(prefix)
 	if err != nil {
@@ -82,7 +252,7 @@ func localFileStorageLister(prefix url.URL) []url.URL {
 	basePath := uriToPath(prefix)
 	parentDir := basePath
 	if !isDir(parentDir) {
-		parentDir = path.Dir(parentDir)
+		parentDir = filepath.Dir(parentDir)
 	}
 
 	files, err := ioutil.ReadDir(parentDir)
@@ -94,9 +264,14 @@ func localFileStorageLister(prefix url.URL) []url.URL {
 	for _, file := range files {
 		full := strings.Join([]string{strings.TrimSuffix(parentDir, separator), file.Name()}, separator)
 		if prefix.Scheme != "" || basePath == "" {
-			full = path.Join(full)
+			full = filepath.Clean(full)
+		}
+		if prefix.Scheme == "" {
+			// Plain paths are kept as they are, e.g. with backslashes on Windows
+			suggestions = append(suggestions, url.URL{Path: full})
+			continue
 		}
-		if uri, err := url.Parse(full); err == nil {
+		if uri, err := url.Parse(filepath.ToSlash(full)); err == nil {
 			uri.Scheme = prefix.Scheme
 			suggestions = append(suggestions, *uri)
 		}
@@ -105,11 +280,55 @@ func localFileStorageLister(prefix url.URL) []url.URL {
 	return suggestions
 }
 
+// parseFileURI parses file:// URLs leaving out their query and fragment, e.g. copied from a browser.
+// Unlike url.Parse, it decodes percent-encoding in relative paths, e.g. file://with%20space.txt.
+func parseFileURI(raw string) (url.URL, bool) {
+	if !strings.HasPrefix(raw, "file://") {
+		return url.URL{}, false
+	}
+	location := strings.TrimPrefix(raw, "file://")
+	if end := strings.IndexAny(location, "?#"); end >= 0 {
+		location = location[:end]
+	}
+
+	// A relative path starts in the host, e.g. file://dir/a.txt
+	host, filePath := "", location
+	if !strings.HasPrefix(location, "/") {
+		parts := strings.SplitN(location, "/", 2)
+		host, filePath = parts[0], ""
+		if len(parts) == 2 {
+			filePath = "/" + parts[1]
+		}
+	}
+
+	host, err := url.PathUnescape(host)
+	if err != nil {
+		return url.URL{}, false
+	}
+	filePath, err = url.PathUnescape(filePath)
+	if err != nil {
+		return url.URL{}, false
+	}
+	return url.URL{Scheme: "file", Host: host, Path: filePath}, true
+}
+
+// checkLocal makes sure local copies for the editor can be created
+func checkLocal(options Options) error {
+	tmp, err := os.CreateTemp("", "remblob-check")
+	if err != nil {
+		return fmt.Errorf("Can't create temporary files: %w", err)
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
 func init() {
 	registerFileStorage(
 		registrationInfo{
-			storage:           func(uri url.URL) FileStorage { return getLocalFileStorage(uri) },
+			storage:           func(uri url.URL, options Options) (FileStorage, error) { return getLocalFileStorage(uri, options), nil },
 			lister:            localFileStorageLister,
+			check:             checkLocal,
+			parser:            parseFileURI,
 			prefixes:          []string{"", "file://"},
 			completionPrompts: []string{"./"},
 		},
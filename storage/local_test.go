@@ -5,7 +5,9 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -223,3 +225,211 @@ func TestLocalStorageSuggestions(t *testing.T) {
 		})
 	}
 }
+
+func TestLocalStorageWriteMakeDirs(t *testing.T) {
+	cases := []struct {
+		name     string
+		makeDirs bool
+		fails    bool
+	}{
+		{name: "mkdir", makeDirs: true, fails: false},
+		{name: "no-mkdir", makeDirs: false, fails: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			filename := path.Join(t.TempDir(), "out", "new", "data.json")
+
+			fs := getLocalFileStorage(mustStrToURI(t, filename), Options{MakeDirs: tc.makeDirs})
+			_, err := fs.Write([]byte("test"))
+			fs.Close()
+
+			if tc.fails {
+				assert.Error(t, err)
+				assert.NoFileExists(t, filename)
+				return
+			}
+			assert.NoError(t, err)
+			body, _ := os.ReadFile(filename)
+			assert.Equal(t, "test", string(body))
+		})
+	}
+}
+
+func TestLocalStoragePreserveMtime(t *testing.T) {
+	past := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name     string
+		preserve bool
+	}{
+		{name: "preserve", preserve: true},
+		{name: "update", preserve: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			filename := path.Join(t.TempDir(), "data.json")
+			os.WriteFile(filename, []byte("old"), 0600)
+			os.Chtimes(filename, past, past)
+
+			fs := getLocalFileStorage(mustStrToURI(t, filename), Options{PreserveMtime: tc.preserve})
+			_, err := fs.Write([]byte("new"))
+			assert.NoError(t, err)
+			assert.NoError(t, fs.Close())
+
+			body, _ := os.ReadFile(filename)
+			assert.Equal(t, "new", string(body))
+			stat, err := os.Stat(filename)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.preserve, stat.ModTime().Equal(past))
+		})
+	}
+}
+
+func TestLocalStoragePreserveMtimeNewFile(t *testing.T) {
+	filename := path.Join(t.TempDir(), "data.json")
+
+	fs := getLocalFileStorage(mustStrToURI(t, filename), Options{PreserveMtime: true})
+	_, err := fs.Write([]byte("new"))
+	assert.NoError(t, err)
+	assert.NoError(t, fs.Close())
+
+	body, _ := os.ReadFile(filename)
+	assert.Equal(t, "new", string(body))
+}
+
+func TestLocalStorageSymlink(t *testing.T) {
+	cases := []struct {
+		name     string
+		noFollow bool
+		fails    bool
+	}{
+		{name: "follow", noFollow: false, fails: false},
+		{name: "no-follow", noFollow: true, fails: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			target := path.Join(dir, "target.txt")
+			link := path.Join(dir, "link.txt")
+			assert.NoError(t, os.WriteFile(target, []byte("before"), 0644))
+			assert.NoError(t, os.Symlink(target, link))
+
+			options := Options{NoFollowSymlinks: tc.noFollow}
+
+			reader := getLocalFileStorage(mustStrToURI(t, link), options)
+			_, readErr := reader.Read(make([]byte, 10))
+			reader.Close()
+
+			writer := getLocalFileStorage(mustStrToURI(t, link), options)
+			_, writeErr := writer.Write([]byte("after"))
+			writer.Close()
+
+			stat, err := os.Lstat(link)
+			assert.NoError(t, err)
+			assert.NotZero(t, stat.Mode()&os.ModeSymlink, "the link is preserved")
+
+			body, _ := os.ReadFile(target)
+			if tc.fails {
+				assert.ErrorIs(t, readErr, ErrSymlink)
+				assert.ErrorIs(t, writeErr, ErrSymlink)
+				assert.Equal(t, "before", string(body))
+				return
+			}
+			assert.NoError(t, readErr)
+			assert.NoError(t, writeErr)
+			assert.Equal(t, "after", string(body))
+		})
+	}
+}
+
+func TestLocalStorageSpecialCharacterNames(t *testing.T) {
+	for _, name := range []string{"with space.txt", "a+b.txt", "a#b.txt", "a?b.txt", "żółw.txt"} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			filename := path.Join(dir, name)
+			assert.NoError(t, os.WriteFile(filename, []byte("test"), 0644))
+
+			// file:// URLs need ? and # escaped
+			uri, err := ParseURI("file://" + (&url.URL{Path: filename}).EscapedPath())
+			assert.NoError(t, err)
+			fs := getLocalFileStorage(uri, Options{})
+			body := make([]byte, 10)
+			n, err := fs.Read(body)
+			fs.Close()
+
+			assert.NoError(t, err)
+			assert.Equal(t, "test", string(body[:n]))
+		})
+	}
+}
+
+func TestLocalStorageFileURLs(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(path.Join(dir, "path with spaces.txt"), []byte("test"), 0644))
+	assert.NoError(t, os.WriteFile(path.Join(dir, "a.txt"), []byte("test"), 0644))
+
+	cwd := mustGetCWD(t)
+	os.Chdir(dir)
+	defer os.Chdir(cwd)
+
+	for _, raw := range []string{
+		"file://path%20with%20spaces.txt",
+		"file://" + dir + "/path%20with%20spaces.txt",
+		"file://a.txt?x=1",
+		"file://" + dir + "/a.txt?x=1#top",
+		"file://a.txt#top",
+	} {
+		t.Run(raw, func(t *testing.T) {
+			uri, err := ParseURI(raw)
+			assert.NoError(t, err)
+
+			fs := getLocalFileStorage(uri, Options{})
+			assert.Equal(t, "test", mustReadAll(t, fs))
+			assert.NoError(t, fs.Close())
+		})
+	}
+}
+
+func TestLocalStorageExpansion(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("OUT_DIR", path.Join(home, "out"))
+	assert.NoError(t, os.Mkdir(path.Join(home, "out"), 0755))
+
+	cases := []struct {
+		raw      string
+		expected string
+	}{
+		{raw: "~/a.txt", expected: path.Join(home, "a.txt")},
+		{raw: "file://~/b.txt", expected: path.Join(home, "b.txt")},
+		{raw: "$OUT_DIR/c.txt", expected: path.Join(home, "out", "c.txt")},
+		{raw: "${OUT_DIR}/d.txt", expected: path.Join(home, "out", "d.txt")},
+		{raw: "$HOME/e.txt", expected: path.Join(home, "e.txt")},
+		{raw: "~user/f.txt", expected: "~user/f.txt"},
+		{raw: "$REMBLOB_NOT_SET/g.txt", expected: "$REMBLOB_NOT_SET/g.txt"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.raw, func(t *testing.T) {
+			uri, err := ParseURI(tc.raw)
+			assert.NoError(t, err)
+
+			fs := getLocalFileStorage(uri, Options{})
+			assert.Equal(t, filepath.FromSlash(tc.expected), fs.uri)
+		})
+	}
+
+	// Written to the expanded location
+	uri, _ := ParseURI("~/written.txt")
+	fs := getLocalFileStorage(uri, Options{})
+	_, err := fs.Write([]byte("test"))
+	assert.NoError(t, err)
+	assert.NoError(t, fs.Close())
+	body, err := os.ReadFile(path.Join(home, "written.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "test", string(body))
+}
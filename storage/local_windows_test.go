@@ -0,0 +1,43 @@
+//go:build windows
+// +build windows
+
+package storage
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalStorageWindowsPath(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "file.json")
+	assert.NoError(t, os.WriteFile(filename, []byte("test"), 0644))
+
+	uri, err := ParseURI(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, filename, uriToPath(uri))
+
+	fs := getLocalFileStorage(uri, Options{})
+	body := make([]byte, 10)
+	n, err := fs.Read(body)
+	fs.Close()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "test", string(body[:n]))
+}
+
+func TestLocalStorageWindowsSuggestions(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "a"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a", "a1.txt"), []byte("test"), 0644))
+
+	prefix, err := ParseURI(dir + `\a`)
+	assert.NoError(t, err)
+
+	suggestions := localFileStorageLister(prefix)
+	assert.Equal(t, []url.URL{{Path: dir + `\a\a1.txt`}}, suggestions)
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// localS3Root is the directory local-s3:// buckets are in, unless REMBLOB_LOCAL_S3_ROOT is set
+const localS3Root = "."
+
+// getLocalS3Root is the directory holding the buckets, one directory per bucket
+func getLocalS3Root() string {
+	if root, ok := os.LookupEnv("REMBLOB_LOCAL_S3_ROOT"); ok {
+		return expandPath(root)
+	}
+	return localS3Root
+}
+
+// localS3Path maps local-s3://bucket/key to <root>/bucket/key. Keys can't climb out of their bucket.
+func localS3Path(uri url.URL, root string) string {
+	key := path.Clean("/" + uriPath(uri))
+	return filepath.Join(root, uri.Host, filepath.FromSlash(key))
+}
+
+// getLocalS3FileStorage serves objects from a local directory tree as if it was a bucket, e.g. for testing pipelines offline.
+func getLocalS3FileStorage(uri url.URL, root string, options Options) *localFileStorage {
+	fs := getLocalFileStorage(url.URL{}, options)
+	fs.uri = localS3Path(uri, root)
+	return fs
+}
+
+// localS3FileStorageLister suggests buckets, then keys and "folders" starting with the prefix, like the S3 lister
+func localS3FileStorageLister(prefix url.URL, root string) []url.URL {
+	suggestions := []url.URL{}
+
+	// Suggesting buckets
+	if prefix.Path == "" {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			return suggestions
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && strings.HasPrefix(entry.Name(), prefix.Host) {
+				suggestions = append(suggestions, url.URL{Scheme: prefix.Scheme, Host: entry.Name(), Path: "/"})
+			}
+		}
+		return suggestions
+	}
+
+	// Suggesting keys in a bucket
+	folder, namePrefix := path.Split(strings.TrimPrefix(uriPath(prefix), "/"))
+	entries, err := os.ReadDir(localS3Path(url.URL{Host: prefix.Host, Path: folder}, root))
+	if err != nil {
+		return suggestions
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), namePrefix) {
+			continue
+		}
+		key := folder + entry.Name()
+		if entry.IsDir() {
+			key += "/"
+		}
+		suggestions = append(suggestions, url.URL{Scheme: prefix.Scheme, Host: prefix.Host, Path: key})
+	}
+	return suggestions
+}
+
+// checkLocalS3 makes sure the directory holding the buckets exists
+func checkLocalS3(root string) error {
+	if !isDir(root) {
+		return fmt.Errorf("Local S3 root %s is not a directory, set REMBLOB_LOCAL_S3_ROOT", root)
+	}
+	return nil
+}
+
+func init() {
+	registerFileStorage(
+		registrationInfo{
+			storage: func(uri url.URL, options Options) (FileStorage, error) {
+				return getLocalS3FileStorage(uri, getLocalS3Root(), options), nil
+			},
+			lister: func(prefix url.URL) []url.URL {
+				return localS3FileStorageLister(prefix, getLocalS3Root())
+			},
+			check: func(options Options) error {
+				return checkLocalS3(getLocalS3Root())
+			},
+			prefixes:          []string{"local-s3://"},
+			completionPrompts: []string{},
+		},
+	)
+}
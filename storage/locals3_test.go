@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalS3Path(t *testing.T) {
+	cases := []struct {
+		uri      string
+		expected string
+	}{
+		{uri: "local-s3://bucket/key.json", expected: filepath.Join("root", "bucket", "key.json")},
+		{uri: "local-s3://bucket/path/to/key.json", expected: filepath.Join("root", "bucket", "path", "to", "key.json")},
+		{uri: "local-s3://bucket/with space.json", expected: filepath.Join("root", "bucket", "with space.json")},
+		{uri: "local-s3://bucket/../other/key.json", expected: filepath.Join("root", "bucket", "other", "key.json")},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.uri, func(t *testing.T) {
+			assert.Equal(t, tc.expected, localS3Path(mustStrToURI(t, tc.uri), "root"))
+		})
+	}
+}
+
+func TestLocalS3StorageReadWrite(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "bucket", "path"), 0700)
+	os.WriteFile(filepath.Join(root, "bucket", "path", "a.txt"), []byte("test"), 0600)
+
+	src := getLocalS3FileStorage(mustStrToURI(t, "local-s3://bucket/path/a.txt"), root, Options{})
+	assert.Equal(t, "test", mustReadAll(t, src))
+	assert.NoError(t, src.Close())
+
+	dst := getLocalS3FileStorage(mustStrToURI(t, "local-s3://bucket/new/b.txt"), root, Options{MakeDirs: true})
+	_, err := dst.Write([]byte("changed"))
+	assert.NoError(t, err)
+	assert.NoError(t, dst.Close())
+
+	written, err := os.ReadFile(filepath.Join(root, "bucket", "new", "b.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "changed", string(written))
+}
+
+func TestLocalS3StorageSuggestions(t *testing.T) {
+	root := createTestFileStructure(t)
+
+	cases := []struct {
+		prefix   string
+		expected []string
+	}{
+		{prefix: "local-s3://", expected: []string{"local-s3://a/"}},
+		{prefix: "local-s3://a/", expected: []string{"local-s3://a/a1.txt", "local-s3://a/a2.txt", "local-s3://a/b/"}},
+		{prefix: "local-s3://a/a", expected: []string{"local-s3://a/a1.txt", "local-s3://a/a2.txt"}},
+		{prefix: "local-s3://a/b/c", expected: []string{"local-s3://a/b/c/"}},
+		{prefix: "local-s3://a/b/c/", expected: []string{"local-s3://a/b/c/c1.txt", "local-s3://a/b/c/c2.txt"}},
+		{prefix: "local-s3://missing/", expected: []string{}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.prefix, func(t *testing.T) {
+			suggestions := localS3FileStorageLister(mustStrToURI(t, tc.prefix), root)
+			assert.Equal(t, tc.expected, urisToPaths(suggestions))
+		})
+	}
+}
+
+func TestLocalS3Check(t *testing.T) {
+	assert.NoError(t, checkLocalS3(t.TempDir()))
+	assert.Error(t, checkLocalS3(filepath.Join(t.TempDir(), "missing")))
+}
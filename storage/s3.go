@@ -3,55 +3,349 @@ package storage
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 )
 
 type s3FileStorage struct {
-	key       string
-	bucket    string
-	client    *s3.Client
-	readBlob  *s3.GetObjectOutput
-	writeBuff *bytes.Buffer
+	key             string
+	bucket          string
+	client          s3Client
+	resolveRegion   bool   // Target the bucket's own region instead of the configured one
+	region          string // Region of the bucket named by the location, e.g. in bucket.s3.eu-west-1.amazonaws.com
+	ifModifiedSince time.Time
+	versionID       string
+	storageClass    string
+	readBlob        *s3.GetObjectOutput
+	readMetadata    map[string]string
+	readLength      *int64
+	readHeaders     map[string]string
+	readVersion     string
+	writeBuff       *bytes.Buffer
+	writeMetadata   map[string]string
+	writeHeaders    map[string]string
 }
 
+type s3Client interface {
+	GetObject(context.Context, *s3.GetObjectInput, ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(context.Context, *s3.PutObjectInput, ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	HeadObject(context.Context, *s3.HeadObjectInput, ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	GetBucketLocation(context.Context, *s3.GetBucketLocationInput, ...func(*s3.Options)) (*s3.GetBucketLocationOutput, error)
+	ListObjectsV2(context.Context, *s3.ListObjectsV2Input, ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	CopyObject(context.Context, *s3.CopyObjectInput, ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	ListObjectVersions(context.Context, *s3.ListObjectVersionsInput, ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error)
+}
+
+// latestToken ending a key stands for the most recently modified object under the prefix before it
+const latestToken = "@latest"
+
+// s3BucketRegions caches the region of each bucket, so it is resolved only once.
+var s3BucketRegions = struct {
+	sync.Mutex
+	regions map[string]string
+}{regions: map[string]string{}}
+
 type s3Lister interface {
 	ListBuckets(context.Context, *s3.ListBucketsInput, ...func(*s3.Options)) (*s3.ListBucketsOutput, error)
 	ListObjectsV2(context.Context, *s3.ListObjectsV2Input, ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
 }
 
-func getS3FileStorage(uri url.URL, client *s3.Client) *s3FileStorage {
+// parseAccessPointURI handles locations using an access point ARN as the bucket, e.g.
+// s3://arn:aws:s3:us-east-1:123456789012:accesspoint/name/key. The ARN can't be parsed as a host.
+func parseAccessPointURI(raw string) (url.URL, bool) {
+	scheme := strings.SplitN(raw, "://", 2)
+	if len(scheme) != 2 || !arn.IsARN(scheme[1]) {
+		return url.URL{}, false
+	}
+	parsed, err := arn.Parse(scheme[1])
+	if err != nil {
+		return url.URL{}, false
+	}
+
+	// The resource names the access point, the rest is the key
+	segments := 2
+	if strings.HasPrefix(parsed.Resource, "outpost/") {
+		segments = 4
+	}
+	resource := strings.SplitN(parsed.Resource, "/", segments+1)
+	if len(resource) < segments {
+		return url.URL{}, false
+	}
+	key := ""
+	if len(resource) > segments {
+		key = resource[segments]
+	}
+	parsed.Resource = strings.Join(resource[:segments], "/")
+
+	return url.URL{Scheme: scheme[0], Host: parsed.String(), Path: "/" + key}, true
+}
+
+// s3EndpointHost matches AWS endpoints used as hosts, bucket.s3.region.amazonaws.com in virtual-hosted style URLs
+// and s3.region.amazonaws.com in path style ones. Legacy endpoints use s3-region, or no region.
+var s3EndpointHost = regexp.MustCompile(`^(?:(.+)\.)?s3(?:[.-]([a-z]{2}(?:-[a-z]+)+-[0-9]))?\.amazonaws\.com(?:\.cn)?$`)
+
+// splitS3Location finds the bucket, key and region of locations naming an AWS endpoint, as produced by other S3 tools.
+// Otherwise the host is the bucket, and the region isn't known.
+func splitS3Location(host string, key string) (string, string, string) {
+	match := s3EndpointHost.FindStringSubmatch(host)
+	if match == nil {
+		return host, key, ""
+	}
+
+	bucket, region := match[1], match[2]
+	if bucket == "" {
+		// Path style, s3.region.amazonaws.com/bucket/key
+		parts := strings.SplitN(key, "/", 2)
+		bucket, key = parts[0], ""
+		if len(parts) == 2 {
+			key = parts[1]
+		}
+	}
+	return bucket, key, region
+}
+
+// isObjectLambdaARN checks is the bucket an S3 Object Lambda access point, which only supports reading
+func isObjectLambdaARN(bucket string) bool {
+	parsed, err := arn.Parse(bucket)
+	return err == nil && parsed.Service == "s3-object-lambda"
+}
+
+// An s3Header is a system metadata header of an object, kept from reading it to writing it
+type s3Header struct {
+	name string
+	get  func(*s3.GetObjectOutput) string // Empty when not set
+	set  func(*s3.PutObjectInput, string)
+}
+
+// s3Headers are the headers kept. Object lock settings are only kept while the retention lasts,
+// as an object can't be locked in the past.
+var s3Headers = []s3Header{
+	{
+		name: "Cache-Control",
+		get:  func(o *s3.GetObjectOutput) string { return aws.ToString(o.CacheControl) },
+		set:  func(i *s3.PutObjectInput, v string) { i.CacheControl = &v },
+	},
+	{
+		name: "Content-Disposition",
+		get:  func(o *s3.GetObjectOutput) string { return aws.ToString(o.ContentDisposition) },
+		set:  func(i *s3.PutObjectInput, v string) { i.ContentDisposition = &v },
+	},
+	{
+		name: "Content-Encoding",
+		get:  func(o *s3.GetObjectOutput) string { return aws.ToString(o.ContentEncoding) },
+		set:  func(i *s3.PutObjectInput, v string) { i.ContentEncoding = &v },
+	},
+	{
+		name: "Content-Language",
+		get:  func(o *s3.GetObjectOutput) string { return aws.ToString(o.ContentLanguage) },
+		set:  func(i *s3.PutObjectInput, v string) { i.ContentLanguage = &v },
+	},
+	{
+		name: "Content-Type",
+		get:  func(o *s3.GetObjectOutput) string { return aws.ToString(o.ContentType) },
+		set:  func(i *s3.PutObjectInput, v string) { i.ContentType = &v },
+	},
+	{
+		name: "Expires",
+		get:  func(o *s3.GetObjectOutput) string { return formatS3Time(o.Expires, http.TimeFormat) },
+		set:  func(i *s3.PutObjectInput, v string) { i.Expires = parseS3Time(v, http.TimeFormat) },
+	},
+	{
+		name: "X-Amz-Website-Redirect-Location",
+		get:  func(o *s3.GetObjectOutput) string { return aws.ToString(o.WebsiteRedirectLocation) },
+		set:  func(i *s3.PutObjectInput, v string) { i.WebsiteRedirectLocation = &v },
+	},
+	{
+		name: "X-Amz-Object-Lock-Mode",
+		get: func(o *s3.GetObjectOutput) string {
+			if !isS3Retained(o) {
+				return ""
+			}
+			return string(o.ObjectLockMode)
+		},
+		set: func(i *s3.PutObjectInput, v string) { i.ObjectLockMode = types.ObjectLockMode(v) },
+	},
+	{
+		name: "X-Amz-Object-Lock-Retain-Until-Date",
+		get: func(o *s3.GetObjectOutput) string {
+			if !isS3Retained(o) {
+				return ""
+			}
+			return formatS3Time(o.ObjectLockRetainUntilDate, time.RFC3339)
+		},
+		set: func(i *s3.PutObjectInput, v string) { i.ObjectLockRetainUntilDate = parseS3Time(v, time.RFC3339) },
+	},
+	{
+		name: "X-Amz-Object-Lock-Legal-Hold",
+		get:  func(o *s3.GetObjectOutput) string { return string(o.ObjectLockLegalHoldStatus) },
+		set:  func(i *s3.PutObjectInput, v string) { i.ObjectLockLegalHoldStatus = types.ObjectLockLegalHoldStatus(v) },
+	},
+}
+
+// getS3Headers collects the kept headers of the object read
+func getS3Headers(output *s3.GetObjectOutput) map[string]string {
+	headers := map[string]string{}
+	for _, header := range s3Headers {
+		if value := header.get(output); value != "" {
+			headers[header.name] = value
+		}
+	}
+	return headers
+}
+
+// setS3Headers applies the kept headers to the object to be written. Others are ignored
+func setS3Headers(input *s3.PutObjectInput, headers map[string]string) {
+	for _, header := range s3Headers {
+		if value, ok := headers[header.name]; ok {
+			header.set(input, value)
+		}
+	}
+}
+
+// headS3Output presents a HEAD response as a GET one, to find the kept headers in it
+func headS3Output(head *s3.HeadObjectOutput) *s3.GetObjectOutput {
+	return &s3.GetObjectOutput{
+		CacheControl:              head.CacheControl,
+		ContentDisposition:        head.ContentDisposition,
+		ContentEncoding:           head.ContentEncoding,
+		ContentLanguage:           head.ContentLanguage,
+		ContentType:               head.ContentType,
+		Expires:                   head.Expires,
+		WebsiteRedirectLocation:   head.WebsiteRedirectLocation,
+		ObjectLockMode:            head.ObjectLockMode,
+		ObjectLockRetainUntilDate: head.ObjectLockRetainUntilDate,
+		ObjectLockLegalHoldStatus: head.ObjectLockLegalHoldStatus,
+	}
+}
+
+// copyS3Input turns the object to be written into a copy, keeping what's set on it
+func copyS3Input(put *s3.PutObjectInput) *s3.CopyObjectInput {
+	return &s3.CopyObjectInput{
+		Metadata:                  put.Metadata,
+		StorageClass:              put.StorageClass,
+		ServerSideEncryption:      put.ServerSideEncryption,
+		SSEKMSKeyId:               put.SSEKMSKeyId,
+		BucketKeyEnabled:          put.BucketKeyEnabled,
+		CacheControl:              put.CacheControl,
+		ContentDisposition:        put.ContentDisposition,
+		ContentEncoding:           put.ContentEncoding,
+		ContentLanguage:           put.ContentLanguage,
+		ContentType:               put.ContentType,
+		Expires:                   put.Expires,
+		WebsiteRedirectLocation:   put.WebsiteRedirectLocation,
+		ObjectLockMode:            put.ObjectLockMode,
+		ObjectLockRetainUntilDate: put.ObjectLockRetainUntilDate,
+		ObjectLockLegalHoldStatus: put.ObjectLockLegalHoldStatus,
+	}
+}
+
+// isS3Retained checks is the object under an object lock retention, which lasts
+func isS3Retained(output *s3.GetObjectOutput) bool {
+	return output.ObjectLockMode != "" &&
+		output.ObjectLockRetainUntilDate != nil &&
+		output.ObjectLockRetainUntilDate.After(time.Now())
+}
+
+func formatS3Time(t *time.Time, layout string) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(layout)
+}
+
+// parseS3Time is nil for values which aren't times
+func parseS3Time(value string, layout string) *time.Time {
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+func getS3FileStorage(uri url.URL, client s3Client) *s3FileStorage {
 	fs := new(s3FileStorage)
 	fs.client = client
-	fs.bucket = uri.Host
-	fs.key = strings.TrimLeft(uri.Path, "/")
+	fs.bucket, fs.key, fs.region = splitS3Location(uri.Host, strings.TrimLeft(uriPath(uri), "/"))
 	fs.readBlob = nil
 	return fs
 }
 
-func buildS3Client() (*s3.Client, error) {
-	cfg, err := buildS3Config()
+// An s3Provider describes an S3-compatible service selectable with a preset.
+type s3Provider struct {
+	endpoint      string // Formatted with the region, or with the account when accountEnv is set
+	accountEnv    string
+	signingRegion string // Used when no region is configured
+	pathStyle     bool
+}
+
+// s3Providers are the presets for S3-compatible services. Credentials are resolved
+// the same way as for AWS, e.g. from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+var s3Providers = map[string]s3Provider{
+	"aws":    {},
+	"spaces": {endpoint: "https://%s.digitaloceanspaces.com"},
+	"b2":     {endpoint: "https://s3.%s.backblazeb2.com", pathStyle: true},
+	"wasabi": {endpoint: "https://s3.%s.wasabisys.com", pathStyle: true},
+	"r2": {
+		endpoint:      "https://%s.r2.cloudflarestorage.com",
+		accountEnv:    "CLOUDFLARE_ACCOUNT_ID",
+		signingRegion: "auto",
+		pathStyle:     true,
+	},
+}
+
+func getS3Provider(name string) (s3Provider, error) {
+	if name == "" {
+		return s3Providers["aws"], nil
+	}
+	provider, ok := s3Providers[name]
+	if !ok {
+		return s3Provider{}, fmt.Errorf("Unknown S3 provider: %#v", name)
+	}
+	return provider, nil
+}
+
+func buildS3Client(options Options) (*s3.Client, error) {
+	provider, err := getS3Provider(options.S3Provider)
 	if err != nil {
 		return nil, err
 	}
 
-	client := s3.NewFromConfig(cfg)
-	if _, anonymous := os.LookupEnv("AWS_NO_SIGN_REQUEST"); anonymous {
-		client = s3.NewFromConfig(cfg, func(o *s3.Options) {
-			o.Credentials = aws.AnonymousCredentials{}
-		})
+	cfg, err := buildS3Config(provider, options)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Region == "" {
+		cfg.Region = provider.signingRegion
 	}
 
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = provider.pathStyle
+		o.UseARNRegion = true
+		if _, anonymous := os.LookupEnv("AWS_NO_SIGN_REQUEST"); anonymous {
+			o.Credentials = aws.AnonymousCredentials{}
+		}
+	})
+
 	return client, nil
 }
 
-func buildS3Config() (aws.Config, error) {
+func buildS3Config(provider s3Provider, options Options) (aws.Config, error) {
 	customResolver := aws.EndpointResolverFunc(func(service, region string) (aws.Endpoint, error) {
 		if awsEndpoint, ok := os.LookupEnv("AWS_ENDPOINT"); ok {
 			return aws.Endpoint{
@@ -62,31 +356,161 @@ func buildS3Config() (aws.Config, error) {
 			}, nil
 		}
 
+		if provider.accountEnv != "" {
+			account := os.Getenv(provider.accountEnv)
+			if account == "" {
+				return aws.Endpoint{}, fmt.Errorf("S3 provider needs an account, set %s", provider.accountEnv)
+			}
+			return aws.Endpoint{
+				PartitionID:   "aws",
+				URL:           fmt.Sprintf(provider.endpoint, account),
+				SigningRegion: provider.signingRegion,
+			}, nil
+		}
+
+		if provider.endpoint != "" {
+			if region == "" {
+				return aws.Endpoint{}, fmt.Errorf("S3 provider needs a region, set AWS_REGION")
+			}
+			return aws.Endpoint{
+				PartitionID:   "aws",
+				URL:           fmt.Sprintf(provider.endpoint, region),
+				SigningRegion: region,
+			}, nil
+		}
+
 		// fallback to default
 		return aws.Endpoint{}, &aws.EndpointNotFoundError{}
 	})
 
-	return config.LoadDefaultConfig(context.TODO(),
+	loadOptions := []func(*config.LoadOptions) error{
 		config.WithEndpointResolver(customResolver),
-	)
+	}
+	if options.CredentialsFile != "" {
+		loadOptions = append(loadOptions, config.WithSharedCredentialsFiles([]string{options.CredentialsFile}))
+	}
+	if _, ok := os.LookupEnv("AWS_REGION"); !ok && options.Region != "" {
+		loadOptions = append(loadOptions, config.WithRegion(options.Region))
+	}
+
+	return config.LoadDefaultConfig(context.TODO(), loadOptions...)
+}
+
+// regionOptions make a request target the region of the bucket
+func (s *s3FileStorage) regionOptions() []func(*s3.Options) {
+	if !s.resolveRegion {
+		return nil
+	}
+
+	region := s.region
+	if region == "" {
+		region = getBucketRegion(s.client, s.bucket)
+	}
+	if region == "" {
+		return nil
+	}
+	return []func(*s3.Options){func(o *s3.Options) { o.Region = region }}
+}
+
+// getBucketRegion looks up the region of the bucket. Empty when it can't be resolved.
+func getBucketRegion(client s3Client, bucket string) string {
+	s3BucketRegions.Lock()
+	defer s3BucketRegions.Unlock()
+
+	if region, ok := s3BucketRegions.regions[bucket]; ok {
+		return region
+	}
+
+	region := ""
+	location, err := client.GetBucketLocation(context.TODO(), &s3.GetBucketLocationInput{Bucket: &bucket})
+	if err == nil {
+		switch location.LocationConstraint {
+		case "":
+			region = "us-east-1"
+		case types.BucketLocationConstraintEu:
+			region = "eu-west-1"
+		default:
+			region = string(location.LocationConstraint)
+		}
+	}
+
+	// Failures are cached too, the configured region is used for the bucket then
+	s3BucketRegions.regions[bucket] = region
+	return region
 }
 
 func (s *s3FileStorage) Read(p []byte) (n int, err error) {
 	if s.readBlob == nil {
-		readBlob, err := s.client.GetObject(
-			context.TODO(),
-			&s3.GetObjectInput{Bucket: &s.bucket, Key: &s.key},
-		)
+		input := &s3.GetObjectInput{Bucket: &s.bucket, Key: &s.key, VersionId: s.getVersionID()}
+		if !s.ifModifiedSince.IsZero() {
+			input.IfModifiedSince = &s.ifModifiedSince
+		}
+		readBlob, err := s.client.GetObject(context.TODO(), input, s.regionOptions()...)
+		if hasHTTPStatus(err, http.StatusNotModified) {
+			return 0, ErrNotModified
+		}
 		if err != nil {
 			return 0, err
 		}
 		s.readBlob = readBlob
+		s.readMetadata = readBlob.Metadata
+		s.readLength = &readBlob.ContentLength
+		s.readHeaders = getS3Headers(readBlob)
+		s.readVersion = aws.ToString(readBlob.ETag) + aws.ToString(readBlob.VersionId)
 	}
 
 	return s.readBlob.Body.Read(p)
 }
 
+// getLatestKey finds the most recently modified object under the prefix preceding the latestToken.
+// Objects modified at the same time are told apart by the lexicographically greatest key.
+func (s *s3FileStorage) getLatestKey() (string, error) {
+	prefix := strings.TrimSuffix(s.key, latestToken)
+	input := &s3.ListObjectsV2Input{Bucket: &s.bucket, Prefix: &prefix}
+
+	latestKey := ""
+	var latestModified time.Time
+	for {
+		objects, err := s.client.ListObjectsV2(context.TODO(), input, s.regionOptions()...)
+		if err != nil {
+			return "", err
+		}
+		for _, object := range objects.Contents {
+			if object.Key == nil || strings.HasSuffix(*object.Key, "/") {
+				continue
+			}
+			modified := time.Time{}
+			if object.LastModified != nil {
+				modified = *object.LastModified
+			}
+			if latestKey == "" || modified.After(latestModified) || (modified.Equal(latestModified) && *object.Key > latestKey) {
+				latestKey, latestModified = *object.Key, modified
+			}
+		}
+		if !objects.IsTruncated {
+			break
+		}
+		input.ContinuationToken = objects.NextContinuationToken
+	}
+
+	if latestKey == "" {
+		return "", fmt.Errorf("No objects found under s3://%s/%s", s.bucket, prefix)
+	}
+	return latestKey, nil
+}
+
+// CheckWritable refuses Object Lambda access points, which only transform reads
+func (s *s3FileStorage) CheckWritable() error {
+	if isObjectLambdaARN(s.bucket) {
+		return fmt.Errorf("%w: pass a writable destination, e.g. the bucket behind the Object Lambda access point", ErrReadOnly)
+	}
+	return nil
+}
+
 func (s *s3FileStorage) Write(p []byte) (n int, err error) {
+	if err := s.CheckWritable(); err != nil {
+		return 0, err
+	}
 	if s.writeBuff == nil {
 		s.writeBuff = &bytes.Buffer{}
 	}
@@ -95,13 +519,196 @@ func (s *s3FileStorage) Write(p []byte) (n int, err error) {
 
 func (s *s3FileStorage) putObject() error {
 	reader := bytes.NewReader(s.writeBuff.Bytes()) // Somehow seeker is actually needed
-	_, err := s.client.PutObject(
+	input := &s3.PutObjectInput{
+		Bucket:       &s.bucket,
+		Key:          &s.key,
+		Body:         reader,
+		Metadata:     s.writeMetadata,
+		StorageClass: types.StorageClass(s.storageClass),
+	}
+	setS3Headers(input, s.writeHeaders)
+
+	_, err := s.client.PutObject(context.TODO(), input, s.regionOptions()...)
+	return err
+}
+
+// GetMetadata returns the user defined metadata of the object read.
+func (s *s3FileStorage) GetMetadata() map[string]string {
+	return s.readMetadata
+}
+
+// GetContentLength returns the size of the object read, as reported by S3.
+func (s *s3FileStorage) GetContentLength() (int64, bool) {
+	if s.readLength == nil {
+		return 0, false
+	}
+	return *s.readLength, true
+}
+
+// SetMetadata sets the user defined metadata of the object to be written.
+func (s *s3FileStorage) SetMetadata(metadata map[string]string) {
+	s.writeMetadata = metadata
+}
+
+// GetHeaders returns the HTTP headers of the object read, which are kept on write.
+func (s *s3FileStorage) GetHeaders() map[string]string {
+	return s.readHeaders
+}
+
+// SetHeaders sets the HTTP headers of the object to be written.
+func (s *s3FileStorage) SetHeaders(headers map[string]string) {
+	s.writeHeaders = headers
+}
+
+func (s *s3FileStorage) Exists() (bool, error) {
+	_, err := s.client.HeadObject(
 		context.TODO(),
-		&s3.PutObjectInput{Bucket: &s.bucket, Key: &s.key, Body: reader},
+		&s3.HeadObjectInput{Bucket: &s.bucket, Key: &s.key, VersionId: s.getVersionID()},
+		s.regionOptions()...,
 	)
+
+	if hasHTTPStatus(err, http.StatusNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// UpdateMetadata copies the object onto itself with the new metadata, so its content isn't transferred.
+// The headers, storage class and encryption which a copy would reset are carried over.
+func (s *s3FileStorage) UpdateMetadata(contentType string, metadata map[string]string) error {
+	head, err := s.client.HeadObject(
+		context.TODO(),
+		&s3.HeadObjectInput{Bucket: &s.bucket, Key: &s.key},
+		s.regionOptions()...,
+	)
+	if err != nil {
+		return err
+	}
+
+	merged := map[string]string{}
+	for key, value := range head.Metadata {
+		merged[key] = value
+	}
+	for key, value := range metadata {
+		if value == "" {
+			delete(merged, key)
+			continue
+		}
+		merged[key] = value
+	}
+
+	// A copy resets what isn't given again, so it's given as for writing the object anew
+	put := &s3.PutObjectInput{
+		Metadata:             merged,
+		StorageClass:         head.StorageClass,
+		ServerSideEncryption: head.ServerSideEncryption,
+		SSEKMSKeyId:          head.SSEKMSKeyId,
+		BucketKeyEnabled:     head.BucketKeyEnabled,
+	}
+	setS3Headers(put, getS3Headers(headS3Output(head)))
+	if contentType != "" {
+		put.ContentType = &contentType
+	}
+
+	input := copyS3Input(put)
+	input.Bucket = &s.bucket
+	input.Key = &s.key
+	input.CopySource = aws.String((&url.URL{Path: s.bucket + "/" + s.key}).EscapedPath())
+	input.MetadataDirective = types.MetadataDirectiveReplace
+
+	_, err = s.client.CopyObject(context.TODO(), input, s.regionOptions()...)
 	return err
 }
 
+// GetContentType returns the content type of the object, looked up with a HEAD request.
+func (s *s3FileStorage) GetContentType() (string, error) {
+	head, err := s.client.HeadObject(
+		context.TODO(),
+		&s3.HeadObjectInput{Bucket: &s.bucket, Key: &s.key, VersionId: s.getVersionID()},
+		s.regionOptions()...,
+	)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(head.ContentType), nil
+}
+
+// GetVersion returns the ETag of the object, along with its version id in versioned buckets.
+func (s *s3FileStorage) GetVersion() (string, error) {
+	head, err := s.client.HeadObject(
+		context.TODO(),
+		&s3.HeadObjectInput{Bucket: &s.bucket, Key: &s.key, VersionId: s.getVersionID()},
+		s.regionOptions()...,
+	)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(head.ETag) + aws.ToString(head.VersionId), nil
+}
+
+// GetReadVersion returns the version of the object read, as told by the GET response.
+func (s *s3FileStorage) GetReadVersion() (string, bool) {
+	return s.readVersion, s.readVersion != ""
+}
+
+// getVersionID is the version read, nil for the latest
+func (s *s3FileStorage) getVersionID() *string {
+	if s.versionID == "" {
+		return nil
+	}
+	return &s.versionID
+}
+
+// ListVersions lists the versions and delete markers of the object, the most recent first.
+func (s *s3FileStorage) ListVersions() ([]ObjectVersion, error) {
+	input := &s3.ListObjectVersionsInput{Bucket: &s.bucket, Prefix: &s.key}
+
+	versions := []ObjectVersion{}
+	add := func(key *string, id *string, modified *time.Time, size int64, latest bool, deleteMarker bool) {
+		// The prefix matches longer keys too
+		if aws.ToString(key) != s.key {
+			return
+		}
+		version := ObjectVersion{ID: aws.ToString(id), Size: size, Latest: latest, DeleteMarker: deleteMarker}
+		if modified != nil {
+			version.LastModified = *modified
+		}
+		versions = append(versions, version)
+	}
+
+	for {
+		output, err := s.client.ListObjectVersions(context.TODO(), input, s.regionOptions()...)
+		if err != nil {
+			return nil, err
+		}
+		for _, version := range output.Versions {
+			add(version.Key, version.VersionId, version.LastModified, version.Size, version.IsLatest, false)
+		}
+		for _, marker := range output.DeleteMarkers {
+			add(marker.Key, marker.VersionId, marker.LastModified, 0, marker.IsLatest, true)
+		}
+		if !output.IsTruncated {
+			break
+		}
+		input.KeyMarker = output.NextKeyMarker
+		input.VersionIdMarker = output.NextVersionIdMarker
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].Latest != versions[j].Latest {
+			return versions[i].Latest
+		}
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+	return versions, nil
+}
+
+// hasHTTPStatus checks if the request failed with the status code
+func hasHTTPStatus(err error, statusCode int) bool {
+	var responseError *awshttp.ResponseError
+	return errors.As(err, &responseError) && responseError.HTTPStatusCode() == statusCode
+}
+
 func (s *s3FileStorage) Close() error {
 	if s.readBlob != nil {
 		if err := s.readBlob.Body.Close(); err != nil {
@@ -175,19 +782,86 @@ func s3FileStorageLister(prefix url.URL, client s3Lister) []url.URL {
 	return suggestions
 }
 
-func init() {
-	client, err := buildS3Client()
-	if err != nil {
-		fmt.Printf("S3 not available. Could not construct client: %#v\n", err.Error())
-		return
+// checkS3 lists the buckets, which needs the endpoint to be reachable and the credentials to be valid.
+// Being denied the listing still proves the credentials, unlike other refusals such as an unknown key.
+func checkS3(client s3Lister) error {
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	_, err := client.ListBuckets(ctx, nil)
+	var apiError smithy.APIError
+	if errors.As(err, &apiError) && apiError.ErrorCode() == "AccessDenied" {
+		return nil // Signed in, just not allowed to list the buckets
 	}
+	return err
+}
 
-	registerFileStorage(
-		registrationInfo{
-			storage:           func(uri url.URL) FileStorage { return getS3FileStorage(uri, client) },
-			lister:            func(prefix url.URL) []url.URL { return s3FileStorageLister(prefix, client) },
-			prefixes:          []string{"s3://"},
-			completionPrompts: []string{},
+// s3FileStorageRegistration registers S3 storage under a prefix.
+// A non-empty provider overrides the one chosen with the options.
+func s3FileStorageRegistration(prefix string, provider string) registrationInfo {
+	build := func(uri url.URL, options Options) (*s3FileStorage, error) {
+		if provider != "" {
+			options.S3Provider = provider
+		}
+		client, err := buildS3Client(options)
+		if err != nil {
+			return nil, fmt.Errorf("S3 not available. Could not construct client: %w", err)
+		}
+		fs := getS3FileStorage(uri, client)
+		// Only AWS itself can tell the region of a bucket
+		s3Provider, _ := getS3Provider(options.S3Provider)
+		_, customEndpoint := os.LookupEnv("AWS_ENDPOINT")
+		// Access point ARNs carry their region
+		fs.resolveRegion = s3Provider.endpoint == "" && !customEndpoint && !arn.IsARN(fs.bucket)
+		fs.ifModifiedSince = options.IfModifiedSince
+		fs.versionID = options.VersionID
+		fs.storageClass = options.StorageClass
+		return fs, nil
+	}
+
+	return registrationInfo{
+		storage: func(uri url.URL, options Options) (FileStorage, error) {
+			return build(uri, options)
 		},
-	)
+		resolver: func(uri url.URL, options Options) (url.URL, error) {
+			if !strings.HasSuffix(uriPath(uri), latestToken) {
+				return uri, nil
+			}
+			fs, err := build(uri, options)
+			if err != nil {
+				return uri, err
+			}
+			key, err := fs.getLatestKey()
+			if err != nil {
+				return uri, err
+			}
+			// The bucket may be part of the path, e.g. s3://s3.eu-west-1.amazonaws.com/bucket/key
+			return url.URL{Scheme: uri.Scheme, Host: uri.Host, Path: strings.TrimSuffix(uriPath(uri), fs.key) + key}, nil
+		},
+		lister: func(prefix url.URL) []url.URL {
+			client, err := buildS3Client(Options{S3Provider: provider})
+			if err != nil {
+				return []url.URL{}
+			}
+			return s3FileStorageLister(prefix, client)
+		},
+		check: func(options Options) error {
+			if provider != "" {
+				options.S3Provider = provider
+			}
+			client, err := buildS3Client(options)
+			if err != nil {
+				return fmt.Errorf("Could not construct client: %w", err)
+			}
+			return checkS3(client)
+		},
+		parser:            parseAccessPointURI,
+		prefixes:          []string{prefix},
+		completionPrompts: []string{},
+	}
+}
+
+func init() {
+	registerFileStorage(s3FileStorageRegistration("s3://", ""))
+	registerFileStorage(s3FileStorageRegistration("r2://", "r2"))
 }
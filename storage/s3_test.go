@@ -1,15 +1,27 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
 	"regexp"
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -41,9 +53,13 @@ var blobs = map[string][]string{
 
 type mockS3Lister struct {
 	Buckets map[string][]string
+	Err     error // Returned when listing the buckets, if set
 }
 
 func (m *mockS3Lister) ListBuckets(context.Context, *s3.ListBucketsInput, ...func(*s3.Options)) (*s3.ListBucketsOutput, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
 	output := s3.ListBucketsOutput{}
 	bucketNames := []string{}
 
@@ -172,3 +188,962 @@ func TestS3StorageSuggestions(t *testing.T) {
 		})
 	}
 }
+
+func TestS3ProviderEndpoints(t *testing.T) {
+	cases := []struct {
+		provider string
+		expected string
+	}{
+		{provider: "spaces", expected: "https://nyc3.digitaloceanspaces.com"},
+		{provider: "b2", expected: "https://s3.nyc3.backblazeb2.com"},
+		{provider: "wasabi", expected: "https://s3.nyc3.wasabisys.com"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.provider, func(t *testing.T) {
+			provider, err := getS3Provider(tc.provider)
+			assert.NoError(t, err)
+
+			cfg, err := buildS3Config(provider, Options{})
+			assert.NoError(t, err)
+
+			endpoint, err := cfg.EndpointResolver.ResolveEndpoint("s3", "nyc3")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, endpoint.URL)
+		})
+	}
+}
+
+func TestS3ProviderUnknown(t *testing.T) {
+	_, err := getS3Provider("unknown")
+	assert.Error(t, err)
+}
+
+func TestS3ProviderR2Endpoint(t *testing.T) {
+	t.Setenv("CLOUDFLARE_ACCOUNT_ID", "an-account")
+
+	provider, err := getS3Provider("r2")
+	assert.NoError(t, err)
+
+	cfg, err := buildS3Config(provider, Options{})
+	assert.NoError(t, err)
+
+	endpoint, err := cfg.EndpointResolver.ResolveEndpoint("s3", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://an-account.r2.cloudflarestorage.com", endpoint.URL)
+	assert.Equal(t, "auto", endpoint.SigningRegion)
+}
+
+func TestS3ProviderR2NoAccount(t *testing.T) {
+	t.Setenv("CLOUDFLARE_ACCOUNT_ID", "")
+
+	provider, err := getS3Provider("r2")
+	assert.NoError(t, err)
+
+	cfg, err := buildS3Config(provider, Options{})
+	assert.NoError(t, err)
+
+	_, err = cfg.EndpointResolver.ResolveEndpoint("s3", "")
+	assert.Error(t, err)
+}
+
+func TestS3CredentialsFile(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_PROFILE", "")
+
+	credentialsFile := path.Join(t.TempDir(), "credentials")
+	body := "[default]\naws_access_key_id = custom-id\naws_secret_access_key = custom-secret\n"
+	if err := os.WriteFile(credentialsFile, []byte(body), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := buildS3Config(s3Provider{}, Options{CredentialsFile: credentialsFile})
+	assert.NoError(t, err)
+
+	credentials, err := cfg.Credentials.Retrieve(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, "custom-id", credentials.AccessKeyID)
+	assert.Equal(t, "custom-secret", credentials.SecretAccessKey)
+}
+
+func TestS3SchemeDefaults(t *testing.T) {
+	t.Setenv("AWS_ENDPOINT", "http://localhost:1")
+	options := Options{Schemes: map[string]SchemeDefaults{
+		"s3": {Region: "eu-west-1", StorageClass: "INTELLIGENT_TIERING"},
+		"r2": {StorageClass: "STANDARD"},
+	}}
+
+	fs, err := GetFileStorage(mustStrToURI(t, "s3://bucket/a.txt"), options)
+	assert.NoError(t, err)
+	assert.Equal(t, "INTELLIGENT_TIERING", fs.(*s3FileStorage).storageClass)
+
+	fs, err = GetFileStorage(mustStrToURI(t, "r2://bucket/a.txt"), options)
+	assert.NoError(t, err)
+	assert.Equal(t, "STANDARD", fs.(*s3FileStorage).storageClass)
+
+	// Options set explicitly win over the scheme's defaults
+	options.StorageClass = "GLACIER"
+	fs, err = GetFileStorage(mustStrToURI(t, "s3://bucket/a.txt"), options)
+	assert.NoError(t, err)
+	assert.Equal(t, "GLACIER", fs.(*s3FileStorage).storageClass)
+
+	// The region applies unless set in the environment
+	t.Setenv("AWS_REGION", "")
+	os.Unsetenv("AWS_REGION")
+	cfg, err := buildS3Config(s3Provider{}, options.forScheme("s3"))
+	assert.NoError(t, err)
+	assert.Equal(t, "eu-west-1", cfg.Region)
+
+	t.Setenv("AWS_REGION", "us-east-2")
+	cfg, err = buildS3Config(s3Provider{}, options.forScheme("s3"))
+	assert.NoError(t, err)
+	assert.Equal(t, "us-east-2", cfg.Region)
+}
+
+func TestS3StorageStorageClass(t *testing.T) {
+	client := &mockS3Client{Objects: map[string]mockS3Object{}}
+	fs := getS3FileStorage(mustStrToURI(t, "s3://bucket/a.txt"), client)
+	fs.storageClass = "INTELLIGENT_TIERING"
+
+	_, err := fs.Write([]byte("test"))
+	assert.NoError(t, err)
+	assert.NoError(t, fs.Close())
+
+	assert.Equal(t, types.StorageClassIntelligentTiering, client.Objects["bucket/a.txt"].storageClass)
+}
+
+type mockS3Object struct {
+	body         string
+	metadata     map[string]string
+	lastModified time.Time
+	contentType  string
+	storageClass types.StorageClass
+	encryption   types.ServerSideEncryption
+	kmsKeyID     string
+	headers      map[string]string // Headers kept on write, by their canonical names
+}
+
+func httpStatusError(statusCode int) error {
+	return &awshttp.ResponseError{
+		ResponseError: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{StatusCode: statusCode}},
+			Err:      fmt.Errorf("status %d", statusCode),
+		},
+	}
+}
+
+// mockS3Version is a version of an object in a versioned bucket
+type mockS3Version struct {
+	id           string
+	body         string
+	lastModified time.Time
+	latest       bool
+	deleteMarker bool
+}
+
+type mockS3Client struct {
+	Objects  map[string]mockS3Object    // Keyed by bucket/key
+	Versions map[string][]mockS3Version // Versions of objects in versioned buckets, keyed by bucket/key
+	Regions  map[string]string          // Buckets outside of us-east-1
+	PageSize int                        // Objects listed per page, 0 lists all at once
+}
+
+// getVersion finds the version of the object, as S3 would with a versionId
+func (m *mockS3Client) getVersion(name string, versionID string) (mockS3Version, bool) {
+	for _, version := range m.Versions[name] {
+		if version.id == versionID && !version.deleteMarker {
+			return version, true
+		}
+	}
+	return mockS3Version{}, false
+}
+
+// checkRegion fails like S3 does when a bucket is accessed through the wrong region
+func (m *mockS3Client) checkRegion(bucket string, optFns []func(*s3.Options)) error {
+	region, ok := m.Regions[bucket]
+	if !ok {
+		region = "us-east-1"
+	}
+
+	options := s3.Options{Region: "us-east-1"}
+	for _, fn := range optFns {
+		fn(&options)
+	}
+
+	if options.Region != region {
+		return fmt.Errorf("PermanentRedirect: bucket %s is in %s", bucket, region)
+	}
+	return nil
+}
+
+func (m *mockS3Client) GetBucketLocation(ctx context.Context, params *s3.GetBucketLocationInput, optFns ...func(*s3.Options)) (*s3.GetBucketLocationOutput, error) {
+	return &s3.GetBucketLocationOutput{
+		LocationConstraint: types.BucketLocationConstraint(m.Regions[*params.Bucket]),
+	}, nil
+}
+
+func (m *mockS3Client) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	if err := m.checkRegion(*params.Bucket, optFns); err != nil {
+		return nil, err
+	}
+	if params.VersionId != nil {
+		version, ok := m.getVersion(*params.Bucket+"/"+*params.Key, *params.VersionId)
+		if !ok {
+			return nil, httpStatusError(http.StatusNotFound)
+		}
+		etag := fmt.Sprintf("\"%x\"", md5.Sum([]byte(version.body)))
+		return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(version.body)), ETag: &etag, VersionId: params.VersionId}, nil
+	}
+	object, ok := m.Objects[*params.Bucket+"/"+*params.Key]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	if params.IfModifiedSince != nil && !object.lastModified.After(*params.IfModifiedSince) {
+		return nil, httpStatusError(http.StatusNotModified)
+	}
+	output := mockGetObjectHeaders(object.headers)
+	output.Body = io.NopCloser(strings.NewReader(object.body))
+	output.ContentLength = int64(len(object.body))
+	output.Metadata = object.metadata
+	output.ETag = aws.String(fmt.Sprintf("\"%x\"", md5.Sum([]byte(object.body))))
+	return output, nil
+}
+
+func (m *mockS3Client) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if err := m.checkRegion(*params.Bucket, optFns); err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.Objects[*params.Bucket+"/"+*params.Key] = mockS3Object{
+		body:         string(body),
+		metadata:     params.Metadata,
+		storageClass: params.StorageClass,
+		headers:      mockPutObjectHeaders(params),
+	}
+	return &s3.PutObjectOutput{}, nil
+}
+
+// mockGetObjectHeaders sets the headers on the output, as S3 would
+func mockGetObjectHeaders(headers map[string]string) *s3.GetObjectOutput {
+	output := &s3.GetObjectOutput{}
+	get := func(name string) *string {
+		if value, ok := headers[name]; ok {
+			return &value
+		}
+		return nil
+	}
+	getTime := func(name string, layout string) *time.Time {
+		if t, err := time.Parse(layout, headers[name]); err == nil {
+			return &t
+		}
+		return nil
+	}
+
+	output.CacheControl = get("Cache-Control")
+	output.ContentDisposition = get("Content-Disposition")
+	output.ContentEncoding = get("Content-Encoding")
+	output.ContentLanguage = get("Content-Language")
+	output.ContentType = get("Content-Type")
+	output.Expires = getTime("Expires", http.TimeFormat)
+	output.WebsiteRedirectLocation = get("X-Amz-Website-Redirect-Location")
+	output.ObjectLockMode = types.ObjectLockMode(headers["X-Amz-Object-Lock-Mode"])
+	output.ObjectLockRetainUntilDate = getTime("X-Amz-Object-Lock-Retain-Until-Date", time.RFC3339)
+	output.ObjectLockLegalHoldStatus = types.ObjectLockLegalHoldStatus(headers["X-Amz-Object-Lock-Legal-Hold"])
+	return output
+}
+
+// mockPutObjectHeaders collects the headers set on the input. Nil when none are
+func mockPutObjectHeaders(input *s3.PutObjectInput) map[string]string {
+	headers := map[string]string{}
+	set := func(name string, value string) {
+		if value != "" {
+			headers[name] = value
+		}
+	}
+	formatTime := func(t *time.Time, layout string) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(layout)
+	}
+
+	set("Cache-Control", aws.ToString(input.CacheControl))
+	set("Content-Disposition", aws.ToString(input.ContentDisposition))
+	set("Content-Encoding", aws.ToString(input.ContentEncoding))
+	set("Content-Language", aws.ToString(input.ContentLanguage))
+	set("Content-Type", aws.ToString(input.ContentType))
+	set("Expires", formatTime(input.Expires, http.TimeFormat))
+	set("X-Amz-Website-Redirect-Location", aws.ToString(input.WebsiteRedirectLocation))
+	set("X-Amz-Object-Lock-Mode", string(input.ObjectLockMode))
+	set("X-Amz-Object-Lock-Retain-Until-Date", formatTime(input.ObjectLockRetainUntilDate, time.RFC3339))
+	set("X-Amz-Object-Lock-Legal-Hold", string(input.ObjectLockLegalHoldStatus))
+	if len(headers) == 0 {
+		return nil
+	}
+	return headers
+}
+
+func (m *mockS3Client) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	if err := m.checkRegion(*params.Bucket, optFns); err != nil {
+		return nil, err
+	}
+	if params.VersionId != nil {
+		version, ok := m.getVersion(*params.Bucket+"/"+*params.Key, *params.VersionId)
+		if !ok {
+			return nil, httpStatusError(http.StatusNotFound)
+		}
+		etag := fmt.Sprintf("\"%x\"", md5.Sum([]byte(version.body)))
+		return &s3.HeadObjectOutput{ETag: &etag, VersionId: params.VersionId}, nil
+	}
+	object, ok := m.Objects[*params.Bucket+"/"+*params.Key]
+	if !ok {
+		return nil, &types.NotFound{}
+	}
+	etag := fmt.Sprintf("\"%x\"", md5.Sum([]byte(object.body)))
+	headers := mockGetObjectHeaders(object.headers)
+	if object.contentType != "" {
+		headers.ContentType = &object.contentType
+	}
+	return &s3.HeadObjectOutput{
+		Metadata:                  object.metadata,
+		ETag:                      &etag,
+		ContentType:               headers.ContentType,
+		CacheControl:              headers.CacheControl,
+		ContentDisposition:        headers.ContentDisposition,
+		ContentEncoding:           headers.ContentEncoding,
+		ContentLanguage:           headers.ContentLanguage,
+		Expires:                   headers.Expires,
+		WebsiteRedirectLocation:   headers.WebsiteRedirectLocation,
+		ObjectLockMode:            headers.ObjectLockMode,
+		ObjectLockRetainUntilDate: headers.ObjectLockRetainUntilDate,
+		ObjectLockLegalHoldStatus: headers.ObjectLockLegalHoldStatus,
+		StorageClass:              object.storageClass,
+		ServerSideEncryption:      object.encryption,
+		SSEKMSKeyId:               aws.String(object.kmsKeyID),
+	}, nil
+}
+
+func (m *mockS3Client) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	if err := m.checkRegion(*params.Bucket, optFns); err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for name := range m.Objects {
+		key := strings.TrimPrefix(name, *params.Bucket+"/")
+		if key != name && strings.HasPrefix(key, *params.Prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	// Continuation tokens are the index of the page's first key
+	start := 0
+	if params.ContinuationToken != nil {
+		fmt.Sscan(*params.ContinuationToken, &start)
+	}
+	end := len(keys)
+	if m.PageSize > 0 && start+m.PageSize < end {
+		end = start + m.PageSize
+	}
+
+	output := &s3.ListObjectsV2Output{}
+	for _, key := range keys[start:end] {
+		key, modified := key, m.Objects[*params.Bucket+"/"+key].lastModified
+		output.Contents = append(output.Contents, types.Object{Key: &key, LastModified: &modified})
+	}
+	if end < len(keys) {
+		next := fmt.Sprint(end)
+		output.IsTruncated = true
+		output.NextContinuationToken = &next
+	}
+	return output, nil
+}
+
+func (m *mockS3Client) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	if err := m.checkRegion(*params.Bucket, optFns); err != nil {
+		return nil, err
+	}
+	source, err := url.PathUnescape(*params.CopySource)
+	if err != nil {
+		return nil, err
+	}
+	object, ok := m.Objects[source]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	if params.MetadataDirective == types.MetadataDirectiveReplace {
+		object.metadata = params.Metadata
+		object.contentType = aws.ToString(params.ContentType)
+		object.headers = mockPutObjectHeaders(&s3.PutObjectInput{
+			CacheControl:              params.CacheControl,
+			ContentDisposition:        params.ContentDisposition,
+			ContentEncoding:           params.ContentEncoding,
+			ContentLanguage:           params.ContentLanguage,
+			Expires:                   params.Expires,
+			WebsiteRedirectLocation:   params.WebsiteRedirectLocation,
+			ObjectLockMode:            params.ObjectLockMode,
+			ObjectLockRetainUntilDate: params.ObjectLockRetainUntilDate,
+			ObjectLockLegalHoldStatus: params.ObjectLockLegalHoldStatus,
+		})
+	}
+	// As on S3, a copy is stored as given, not as the source was
+	object.storageClass = params.StorageClass
+	object.encryption = params.ServerSideEncryption
+	object.kmsKeyID = aws.ToString(params.SSEKMSKeyId)
+	m.Objects[*params.Bucket+"/"+*params.Key] = object
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (m *mockS3Client) ListObjectVersions(ctx context.Context, params *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+	if err := m.checkRegion(*params.Bucket, optFns); err != nil {
+		return nil, err
+	}
+	type keyVersion struct {
+		key     string
+		version mockS3Version
+	}
+	names := []string{}
+	for name := range m.Versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	all := []keyVersion{}
+	for _, name := range names {
+		key := strings.TrimPrefix(name, *params.Bucket+"/")
+		if key != name && strings.HasPrefix(key, *params.Prefix) {
+			for _, version := range m.Versions[name] {
+				all = append(all, keyVersion{key: key, version: version})
+			}
+		}
+	}
+
+	// Key markers are the index of the page's first version
+	start := 0
+	if params.KeyMarker != nil {
+		fmt.Sscan(*params.KeyMarker, &start)
+	}
+	end := len(all)
+	if m.PageSize > 0 && start+m.PageSize < end {
+		end = start + m.PageSize
+	}
+
+	output := &s3.ListObjectVersionsOutput{}
+	for _, entry := range all[start:end] {
+		key, id, modified := entry.key, entry.version.id, entry.version.lastModified
+		if entry.version.deleteMarker {
+			output.DeleteMarkers = append(output.DeleteMarkers, types.DeleteMarkerEntry{
+				Key: &key, VersionId: &id, LastModified: &modified, IsLatest: entry.version.latest,
+			})
+			continue
+		}
+		output.Versions = append(output.Versions, types.ObjectVersion{
+			Key: &key, VersionId: &id, LastModified: &modified, IsLatest: entry.version.latest, Size: int64(len(entry.version.body)),
+		})
+	}
+	if end < len(all) {
+		next := fmt.Sprint(end)
+		output.IsTruncated = true
+		output.NextKeyMarker = &next
+		output.NextVersionIdMarker = &next
+	}
+	return output, nil
+}
+
+func mustReadAll(t *testing.T, reader io.Reader) string {
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestS3StorageMetadata(t *testing.T) {
+	client := &mockS3Client{Objects: map[string]mockS3Object{
+		"bucket/src.txt": {body: "test", metadata: map[string]string{"team": "x"}},
+	}}
+
+	src := getS3FileStorage(mustStrToURI(t, "s3://bucket/src.txt"), client)
+	assert.Equal(t, "test", mustReadAll(t, src))
+	assert.Equal(t, map[string]string{"team": "x"}, src.GetMetadata())
+	assert.NoError(t, src.Close())
+
+	dst := getS3FileStorage(mustStrToURI(t, "s3://bucket/dst.txt"), client)
+	dst.SetMetadata(map[string]string{"team": "y"})
+	_, err := io.Copy(dst, bytes.NewBufferString("changed"))
+	assert.NoError(t, err)
+	assert.NoError(t, dst.Close())
+
+	expected := mockS3Object{body: "changed", metadata: map[string]string{"team": "y"}}
+	assert.Equal(t, expected, client.Objects["bucket/dst.txt"])
+}
+
+func TestS3StorageHeaders(t *testing.T) {
+	expires := time.Date(2031, 9, 1, 0, 0, 0, 0, time.UTC)
+	retainUntil := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	retained := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+
+	cases := []struct {
+		name     string
+		headers  map[string]string
+		expected map[string]string
+	}{
+		{
+			name:     "none",
+			headers:  nil,
+			expected: map[string]string{},
+		},
+		{
+			name: "content",
+			headers: map[string]string{
+				"Cache-Control":       "max-age=60",
+				"Content-Disposition": "attachment; filename=a.txt",
+				"Content-Encoding":    "identity",
+				"Content-Language":    "pl",
+				"Content-Type":        "text/plain",
+				"Expires":             expires.Format(http.TimeFormat),
+			},
+		},
+		{
+			name:    "website redirect",
+			headers: map[string]string{"X-Amz-Website-Redirect-Location": "/other.html"},
+		},
+		{
+			name: "object lock",
+			headers: map[string]string{
+				"X-Amz-Object-Lock-Mode":              "GOVERNANCE",
+				"X-Amz-Object-Lock-Retain-Until-Date": retainUntil.Format(time.RFC3339),
+				"X-Amz-Object-Lock-Legal-Hold":        "ON",
+			},
+		},
+		{
+			name: "object lock expired",
+			headers: map[string]string{
+				"X-Amz-Object-Lock-Mode":              "GOVERNANCE",
+				"X-Amz-Object-Lock-Retain-Until-Date": retained.Format(time.RFC3339),
+			},
+			expected: map[string]string{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			expected := tc.expected
+			if expected == nil {
+				expected = tc.headers
+			}
+			client := &mockS3Client{Objects: map[string]mockS3Object{"bucket/src.txt": {body: "test", headers: tc.headers}}}
+
+			src := getS3FileStorage(mustStrToURI(t, "s3://bucket/src.txt"), client)
+			mustReadAll(t, src)
+			assert.Equal(t, expected, src.GetHeaders())
+			assert.NoError(t, src.Close())
+
+			dst := getS3FileStorage(mustStrToURI(t, "s3://bucket/dst.txt"), client)
+			dst.SetHeaders(src.GetHeaders())
+			_, err := dst.Write([]byte("changed"))
+			assert.NoError(t, err)
+			assert.NoError(t, dst.Close())
+
+			if len(expected) == 0 {
+				assert.Nil(t, client.Objects["bucket/dst.txt"].headers)
+				return
+			}
+			assert.Equal(t, expected, client.Objects["bucket/dst.txt"].headers)
+		})
+	}
+}
+
+func TestS3StorageSetHeaders(t *testing.T) {
+	client := &mockS3Client{Objects: map[string]mockS3Object{}}
+
+	dst := getS3FileStorage(mustStrToURI(t, "s3://bucket/dst.txt"), client)
+	dst.SetHeaders(map[string]string{"Content-Disposition": "inline", "X-Unknown": "ignored"})
+	_, err := dst.Write([]byte("changed"))
+	assert.NoError(t, err)
+	assert.NoError(t, dst.Close())
+
+	assert.Equal(t, map[string]string{"Content-Disposition": "inline"}, client.Objects["bucket/dst.txt"].headers)
+}
+
+func TestS3StorageSpecialCharacterKeys(t *testing.T) {
+	cases := []struct {
+		uri string
+		key string
+	}{
+		{uri: "s3://bucket/dir/with space.txt", key: "dir/with space.txt"},
+		{uri: "s3://bucket/dir/a+b.txt", key: "dir/a+b.txt"},
+		{uri: "s3://bucket/dir/a#b.txt", key: "dir/a#b.txt"},
+		{uri: "s3://bucket/dir/a?b=c.txt", key: "dir/a?b=c.txt"},
+		{uri: "s3://bucket/dir/a?b%20c.txt", key: "dir/a?b c.txt"},
+		{uri: "s3://bucket/dir/100%25.txt", key: "dir/100%.txt"},
+		{uri: "s3://bucket/dir/żółw.txt", key: "dir/żółw.txt"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.key, func(t *testing.T) {
+			client := &mockS3Client{Objects: map[string]mockS3Object{"bucket/" + tc.key: {body: "test"}}}
+
+			fs := getS3FileStorage(mustStrToURI(t, tc.uri), client)
+			assert.Equal(t, tc.key, fs.key)
+			assert.Equal(t, "test", mustReadAll(t, fs))
+			assert.NoError(t, fs.Close())
+		})
+	}
+}
+
+func TestS3StorageAccessPointARN(t *testing.T) {
+	accessPoint := "arn:aws:s3:us-west-2:123456789012:accesspoint/name"
+	outpost := "arn:aws:s3-outposts:us-west-2:123456789012:outpost/op-01234567890123456/accesspoint/name"
+	objectLambda := "arn:aws:s3-object-lambda:us-west-2:123456789012:accesspoint/name"
+
+	cases := []struct {
+		uri    string
+		bucket string
+		key    string
+	}{
+		{uri: "s3://" + accessPoint + "/dir/blob.json", bucket: accessPoint, key: "dir/blob.json"},
+		{uri: "s3://" + accessPoint + "/blob.json", bucket: accessPoint, key: "blob.json"},
+		{uri: "s3://" + outpost + "/blob.json", bucket: outpost, key: "blob.json"},
+		{uri: "s3://" + objectLambda + "/dir/blob.json", bucket: objectLambda, key: "dir/blob.json"},
+		{uri: "s3://bucket/blob.json", bucket: "bucket", key: "blob.json"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.uri, func(t *testing.T) {
+			uri, err := ParseURI(tc.uri)
+			assert.NoError(t, err)
+
+			client := &mockS3Client{Objects: map[string]mockS3Object{tc.bucket + "/" + tc.key: {body: "test"}}}
+			fs := getS3FileStorage(uri, client)
+
+			assert.Equal(t, tc.bucket, fs.bucket)
+			assert.Equal(t, tc.key, fs.key)
+			assert.Equal(t, "test", mustReadAll(t, fs))
+			assert.NoError(t, fs.Close())
+		})
+	}
+}
+
+func TestS3StorageObjectLambdaReadOnly(t *testing.T) {
+	uri, err := ParseURI("s3://arn:aws:s3-object-lambda:us-west-2:123456789012:accesspoint/name/blob.json")
+	assert.NoError(t, err)
+
+	client := &mockS3Client{Objects: map[string]mockS3Object{}}
+	fs := getS3FileStorage(uri, client)
+	err = fs.CheckWritable()
+	assert.ErrorIs(t, err, ErrReadOnly)
+	assert.Contains(t, err.Error(), "pass a writable destination")
+
+	_, err = fs.Write([]byte("test"))
+	assert.ErrorIs(t, err, ErrReadOnly)
+	assert.NoError(t, fs.Close())
+	assert.Empty(t, client.Objects)
+}
+
+func TestS3StorageLatestKey(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2021, 9, d, 0, 0, 0, 0, time.UTC) }
+	client := &mockS3Client{
+		Objects: map[string]mockS3Object{
+			"bucket/exports/2021-09-01.csv": {lastModified: day(1)},
+			"bucket/exports/2021-09-03.csv": {lastModified: day(3)},
+			"bucket/exports/2021-09-02.csv": {lastModified: day(4)}, // Re-exported later
+			"bucket/exports/old/a.csv":      {lastModified: day(2)},
+			"bucket/exports/same-b.csv":     {lastModified: day(1)},
+			"bucket/other/2021-09-05.csv":   {lastModified: day(5)},
+			"bucket/same/a.csv":             {lastModified: day(1)},
+			"bucket/same/b.csv":             {lastModified: day(1)},
+		},
+		PageSize: 2,
+	}
+
+	cases := []struct {
+		uri      string
+		expected string
+		fails    bool
+	}{
+		{uri: "s3://bucket/exports/@latest", expected: "exports/2021-09-02.csv"},
+		{uri: "s3://bucket/same/@latest", expected: "same/b.csv"},
+		{uri: "s3://bucket/@latest", expected: "other/2021-09-05.csv"},
+		{uri: "s3://bucket/missing/@latest", fails: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.uri, func(t *testing.T) {
+			fs := getS3FileStorage(mustStrToURI(t, tc.uri), client)
+			key, err := fs.getLatestKey()
+			if tc.fails {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, key)
+		})
+	}
+}
+
+func TestS3StorageVersion(t *testing.T) {
+	client := &mockS3Client{Objects: map[string]mockS3Object{"bucket/a.txt": {body: "test"}}}
+	fs := getS3FileStorage(mustStrToURI(t, "s3://bucket/a.txt"), client)
+
+	before, err := fs.GetVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, `"098f6bcd4621d373cade4e832627b4f6"`, before)
+
+	_, known := fs.GetReadVersion()
+	assert.False(t, known)
+	assert.Equal(t, "test", mustReadAll(t, fs))
+
+	client.Objects["bucket/a.txt"] = mockS3Object{body: "changed"}
+	after, err := fs.GetVersion()
+	assert.NoError(t, err)
+	assert.NotEqual(t, before, after)
+
+	// The version read stays the one of the GET response
+	read, known := fs.GetReadVersion()
+	assert.True(t, known)
+	assert.Equal(t, before, read)
+}
+
+func TestS3StorageListVersions(t *testing.T) {
+	day := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	client := &mockS3Client{
+		Versions: map[string][]mockS3Version{
+			"bucket/a.txt": {
+				{id: "v1", body: "first", lastModified: day},
+				{id: "v3", lastModified: day.Add(2 * time.Hour), latest: true, deleteMarker: true},
+				{id: "v2", body: "second", lastModified: day.Add(time.Hour)},
+			},
+			"bucket/a.txt.bak": {{id: "other", body: "other", lastModified: day, latest: true}},
+		},
+		PageSize: 2,
+	}
+	fs := getS3FileStorage(mustStrToURI(t, "s3://bucket/a.txt"), client)
+
+	versions, err := fs.ListVersions()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []ObjectVersion{
+		{ID: "v3", LastModified: day.Add(2 * time.Hour), Latest: true, DeleteMarker: true},
+		{ID: "v2", LastModified: day.Add(time.Hour), Size: 6},
+		{ID: "v1", LastModified: day, Size: 5},
+	}, versions)
+}
+
+func TestS3StorageReadVersion(t *testing.T) {
+	client := &mockS3Client{
+		Objects: map[string]mockS3Object{"bucket/a.txt": {body: "second"}},
+		Versions: map[string][]mockS3Version{
+			"bucket/a.txt": {{id: "v1", body: "first"}, {id: "v2", body: "second", latest: true}},
+		},
+	}
+
+	fs := getS3FileStorage(mustStrToURI(t, "s3://bucket/a.txt"), client)
+	fs.versionID = "v1"
+	assert.Equal(t, "first", mustReadAll(t, fs))
+
+	version, err := fs.GetVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, `"8b04d5e3775d298e78455efc5ca404d5"v1`, version)
+
+	fs = getS3FileStorage(mustStrToURI(t, "s3://bucket/a.txt"), client)
+	fs.versionID = "missing"
+	exists, err := fs.Exists()
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestS3StorageUpdateMetadata(t *testing.T) {
+	client := &mockS3Client{Objects: map[string]mockS3Object{
+		"bucket/dir/a b.json": {body: "test", metadata: map[string]string{"team": "x", "owner": "y"}, contentType: "text/plain"},
+	}}
+	fs := getS3FileStorage(mustStrToURI(t, "s3://bucket/dir/a b.json"), client)
+
+	err := fs.UpdateMetadata("application/json", map[string]string{"team": "z", "owner": "", "stage": "prod"})
+	assert.NoError(t, err)
+
+	expected := mockS3Object{
+		body:        "test",
+		metadata:    map[string]string{"team": "z", "stage": "prod"},
+		contentType: "application/json",
+	}
+	assert.Equal(t, expected, client.Objects["bucket/dir/a b.json"])
+
+	// The content type is kept unless given
+	assert.NoError(t, fs.UpdateMetadata("", map[string]string{"team": "x"}))
+	assert.Equal(t, "application/json", client.Objects["bucket/dir/a b.json"].contentType)
+}
+
+func TestS3StorageUpdateMetadataKeepsObjectSettings(t *testing.T) {
+	headers := map[string]string{
+		"Cache-Control":                   "no-cache",
+		"X-Amz-Website-Redirect-Location": "/elsewhere.html",
+	}
+	client := &mockS3Client{Objects: map[string]mockS3Object{
+		"bucket/a.json": {
+			body:         "{}",
+			metadata:     map[string]string{"team": "x"},
+			contentType:  "application/json",
+			storageClass: types.StorageClassStandardIa,
+			encryption:   types.ServerSideEncryptionAwsKms,
+			kmsKeyID:     "arn:aws:kms:us-east-1:123456789012:key/a-key",
+			headers:      headers,
+		},
+	}}
+	fs := getS3FileStorage(mustStrToURI(t, "s3://bucket/a.json"), client)
+
+	assert.NoError(t, fs.UpdateMetadata("", map[string]string{"team": "y"}))
+
+	expected := mockS3Object{
+		body:         "{}",
+		metadata:     map[string]string{"team": "y"},
+		contentType:  "application/json",
+		storageClass: types.StorageClassStandardIa,
+		encryption:   types.ServerSideEncryptionAwsKms,
+		kmsKeyID:     "arn:aws:kms:us-east-1:123456789012:key/a-key",
+		headers:      headers,
+	}
+	assert.Equal(t, expected, client.Objects["bucket/a.json"])
+}
+
+func TestS3StorageContentType(t *testing.T) {
+	client := &mockS3Client{Objects: map[string]mockS3Object{"bucket/export": {body: "{}", contentType: "application/json"}}}
+	fs := getS3FileStorage(mustStrToURI(t, "s3://bucket/export"), client)
+
+	contentType, err := fs.GetContentType()
+	assert.NoError(t, err)
+	assert.Equal(t, "application/json", contentType)
+
+	missing := getS3FileStorage(mustStrToURI(t, "s3://bucket/missing"), client)
+	_, err = missing.GetContentType()
+	assert.Error(t, err)
+}
+
+func TestS3StorageBucketRegion(t *testing.T) {
+	s3BucketRegions.regions = map[string]string{}
+
+	client := &mockS3Client{
+		Objects: map[string]mockS3Object{
+			"eu-bucket/a.txt": {body: "test"},
+			"us-bucket/a.txt": {body: "test"},
+		},
+		Regions: map[string]string{"eu-bucket": "eu-central-1"},
+	}
+
+	// Without resolving, the request goes to the wrong region
+	fs := getS3FileStorage(mustStrToURI(t, "s3://eu-bucket/a.txt"), client)
+	_, err := io.ReadAll(fs)
+	assert.Error(t, err)
+
+	for _, uri := range []string{"s3://eu-bucket/a.txt", "s3://us-bucket/a.txt"} {
+		fs := getS3FileStorage(mustStrToURI(t, uri), client)
+		fs.resolveRegion = true
+		assert.Equal(t, "test", mustReadAll(t, fs))
+		assert.NoError(t, fs.Close())
+	}
+
+	expected := map[string]string{"eu-bucket": "eu-central-1", "us-bucket": "us-east-1"}
+	assert.Equal(t, expected, s3BucketRegions.regions)
+}
+
+func TestS3StorageEndpointURIs(t *testing.T) {
+	cases := []struct {
+		uri    string
+		bucket string
+		key    string
+		region string
+	}{
+		{uri: "s3://bucket.s3.eu-central-1.amazonaws.com/dir/a.txt", bucket: "bucket", key: "dir/a.txt", region: "eu-central-1"},
+		{uri: "s3://bucket.s3-eu-central-1.amazonaws.com/dir/a.txt", bucket: "bucket", key: "dir/a.txt", region: "eu-central-1"},
+		{uri: "s3://bucket.with.dots.s3.eu-central-1.amazonaws.com/a.txt", bucket: "bucket.with.dots", key: "a.txt", region: "eu-central-1"},
+		{uri: "s3://bucket.s3.amazonaws.com/dir/a.txt", bucket: "bucket", key: "dir/a.txt"},
+		{uri: "s3://s3.eu-central-1.amazonaws.com/bucket/dir/a.txt", bucket: "bucket", key: "dir/a.txt", region: "eu-central-1"},
+		{uri: "s3://s3.amazonaws.com/bucket/dir/a.txt", bucket: "bucket", key: "dir/a.txt"},
+		{uri: "s3://bucket.s3.cn-north-1.amazonaws.com.cn/a.txt", bucket: "bucket", key: "a.txt", region: "cn-north-1"},
+		{uri: "s3://bucket.s3-accelerate.amazonaws.com/a.txt", bucket: "bucket.s3-accelerate.amazonaws.com", key: "a.txt"},
+		{uri: "s3://bucket/dir/a.txt", bucket: "bucket", key: "dir/a.txt"},
+		{uri: "s3://amazonaws.com/a.txt", bucket: "amazonaws.com", key: "a.txt"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.uri, func(t *testing.T) {
+			fs := getS3FileStorage(mustStrToURI(t, tc.uri), &mockS3Client{})
+
+			assert.Equal(t, tc.bucket, fs.bucket)
+			assert.Equal(t, tc.key, fs.key)
+			assert.Equal(t, tc.region, fs.region)
+		})
+	}
+}
+
+func TestS3StorageEndpointURIRegion(t *testing.T) {
+	s3BucketRegions.regions = map[string]string{}
+
+	client := &mockS3Client{
+		Objects: map[string]mockS3Object{"eu-bucket/a.txt": {body: "test"}},
+		Regions: map[string]string{"eu-bucket": "eu-central-1"},
+	}
+
+	fs := getS3FileStorage(mustStrToURI(t, "s3://eu-bucket.s3.eu-central-1.amazonaws.com/a.txt"), client)
+	fs.resolveRegion = true
+	assert.Equal(t, "test", mustReadAll(t, fs))
+	assert.NoError(t, fs.Close())
+
+	// Known from the location, not looked up
+	assert.Empty(t, s3BucketRegions.regions)
+}
+
+func TestS3StorageIfModifiedSince(t *testing.T) {
+	modified := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	client := &mockS3Client{Objects: map[string]mockS3Object{
+		"bucket/a.txt": {body: "test", lastModified: modified},
+	}}
+
+	cases := []struct {
+		name  string
+		since time.Time
+		err   error
+	}{
+		{name: "unset", since: time.Time{}, err: nil},
+		{name: "modified", since: modified.Add(-time.Hour), err: nil},
+		{name: "not-modified", since: modified, err: ErrNotModified},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := getS3FileStorage(mustStrToURI(t, "s3://bucket/a.txt"), client)
+			fs.ifModifiedSince = tc.since
+
+			_, err := io.ReadAll(fs)
+			assert.Equal(t, tc.err, err)
+		})
+	}
+}
+
+func TestCheckS3(t *testing.T) {
+	cases := []struct {
+		name  string
+		err   error
+		fails bool
+	}{
+		{name: "listed"},
+		{name: "denied listing", err: &smithy.GenericAPIError{Code: "AccessDenied"}},
+		{name: "invalid key", err: &smithy.GenericAPIError{Code: "InvalidAccessKeyId"}, fails: true},
+		{name: "invalid secret", err: &smithy.GenericAPIError{Code: "SignatureDoesNotMatch"}, fails: true},
+		{name: "forbidden without a code", err: httpStatusError(http.StatusForbidden), fails: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkS3(&mockS3Lister{Buckets: blobs, Err: tc.err})
+			if tc.fails {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
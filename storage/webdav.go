@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// webdavSchemes map the WebDAV schemes to the protocol they are served over
+var webdavSchemes = map[string]string{
+	"dav":  "http",
+	"davs": "https",
+}
+
+// webdavFileStorage reads with GET and writes with PUT on Close.
+// Credentials are taken from REMBLOB_DAV_USER and REMBLOB_DAV_PASSWORD.
+type webdavFileStorage struct {
+	url             string
+	client          *http.Client
+	ifModifiedSince time.Time
+	readBody        io.ReadCloser
+	writeBuff       *bytes.Buffer
+}
+
+func getWebDAVFileStorage(uri url.URL, client *http.Client) *webdavFileStorage {
+	webdavURL := getWebDAVURL(uri)
+	fs := new(webdavFileStorage)
+	fs.url = webdavURL.String()
+	fs.client = client
+	return fs
+}
+
+// getWebDAVURL is the http(s) location of the uri
+func getWebDAVURL(uri url.URL) url.URL {
+	return url.URL{Scheme: webdavSchemes[uri.Scheme], Host: uri.Host, Path: uriPath(uri)}
+}
+
+func newWebDAVRequest(method string, url string, body io.Reader) (*http.Request, error) {
+	request, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if user, ok := os.LookupEnv("REMBLOB_DAV_USER"); ok {
+		request.SetBasicAuth(user, os.Getenv("REMBLOB_DAV_PASSWORD"))
+	}
+	return request, nil
+}
+
+// webdavError describes a request the server refused
+func webdavError(response *http.Response) error {
+	return fmt.Errorf("WebDAV %s %s failed: %s", response.Request.Method, response.Request.URL, response.Status)
+}
+
+func (w *webdavFileStorage) Read(p []byte) (n int, err error) {
+	if w.readBody == nil {
+		request, err := newWebDAVRequest(http.MethodGet, w.url, nil)
+		if err != nil {
+			return 0, err
+		}
+		if !w.ifModifiedSince.IsZero() {
+			request.Header.Set("If-Modified-Since", w.ifModifiedSince.UTC().Format(http.TimeFormat))
+		}
+		response, err := w.client.Do(request)
+		if err != nil {
+			return 0, err
+		}
+		switch response.StatusCode {
+		case http.StatusOK:
+		case http.StatusNotModified:
+			response.Body.Close()
+			return 0, ErrNotModified
+		default:
+			response.Body.Close()
+			return 0, webdavError(response)
+		}
+		w.readBody = response.Body
+	}
+
+	return w.readBody.Read(p)
+}
+
+func (w *webdavFileStorage) Write(p []byte) (n int, err error) {
+	if w.writeBuff == nil {
+		w.writeBuff = &bytes.Buffer{}
+	}
+	return w.writeBuff.Write(p)
+}
+
+func (w *webdavFileStorage) put() error {
+	request, err := newWebDAVRequest(http.MethodPut, w.url, bytes.NewReader(w.writeBuff.Bytes()))
+	if err != nil {
+		return err
+	}
+	response, err := w.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	default:
+		return webdavError(response)
+	}
+}
+
+func (w *webdavFileStorage) Close() error {
+	if w.readBody != nil {
+		if err := w.readBody.Close(); err != nil {
+			return err
+		}
+		w.readBody = nil
+	}
+
+	if w.writeBuff != nil {
+		if err := w.put(); err != nil {
+			return err
+		}
+		w.writeBuff = nil
+	}
+	return nil
+}
+
+func (w *webdavFileStorage) Exists() (bool, error) {
+	request, err := newWebDAVRequest(http.MethodHead, w.url, nil)
+	if err != nil {
+		return false, err
+	}
+	response, err := w.client.Do(request)
+	if err != nil {
+		return false, err
+	}
+	response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, webdavError(response)
+	}
+}
+
+// davMultistatus is the part of a PROPFIND response needed for suggestions
+type davMultistatus struct {
+	Responses []struct {
+		Href string `xml:"href"`
+	} `xml:"response"`
+}
+
+func webdavFileStorageLister(prefix url.URL, client *http.Client) []url.URL {
+	suggestions := []url.URL{}
+
+	// Listing the "folder" the prefix is in
+	folder := getWebDAVURL(prefix)
+	if !strings.HasSuffix(folder.Path, "/") {
+		folder.Path = path.Dir(folder.Path) + "/"
+	}
+
+	request, err := newWebDAVRequest("PROPFIND", folder.String(), nil)
+	if err != nil {
+		return suggestions
+	}
+	request.Header.Set("Depth", "1")
+	response, err := client.Do(request)
+	if err != nil {
+		return suggestions
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusMultiStatus {
+		return suggestions
+	}
+
+	var multistatus davMultistatus
+	if err := xml.NewDecoder(response.Body).Decode(&multistatus); err != nil {
+		return suggestions
+	}
+
+	for _, entry := range multistatus.Responses {
+		// Hrefs may be absolute URLs or paths
+		href, err := url.Parse(entry.Href)
+		if err != nil || href.Path == folder.Path {
+			continue
+		}
+		suggestions = append(suggestions, url.URL{Scheme: prefix.Scheme, Host: prefix.Host, Path: href.Path})
+	}
+
+	return suggestions
+}
+
+func init() {
+	registerFileStorage(
+		registrationInfo{
+			storage: func(uri url.URL, options Options) (FileStorage, error) {
+				fs := getWebDAVFileStorage(uri, http.DefaultClient)
+				fs.ifModifiedSince = options.IfModifiedSince
+				return fs, nil
+			},
+			lister: func(prefix url.URL) []url.URL {
+				return webdavFileStorageLister(prefix, http.DefaultClient)
+			},
+			prefixes:          []string{"dav://", "davs://"},
+			completionPrompts: []string{},
+		},
+	)
+}
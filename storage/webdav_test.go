@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockWebDAVServer keeps files in memory, keyed by path, and answers the few requests the storage makes.
+type mockWebDAVServer struct {
+	sync.Mutex
+	files    map[string]string
+	modified time.Time
+	user     string
+	password string
+}
+
+func (m *mockWebDAVServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+
+	if user, password, _ := r.BasicAuth(); user != m.user || password != m.password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	body, ok := m.files[r.URL.Path]
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !m.modified.After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		io.WriteString(w, body)
+	case http.MethodPut:
+		content, _ := io.ReadAll(r.Body)
+		m.files[r.URL.Path] = string(content)
+		w.WriteHeader(http.StatusCreated)
+	case "PROPFIND":
+		names := []string{r.URL.Path}
+		for name := range m.files {
+			if strings.HasPrefix(name, r.URL.Path) && !strings.Contains(strings.TrimPrefix(name, r.URL.Path), "/") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		w.WriteHeader(http.StatusMultiStatus)
+		io.WriteString(w, `<?xml version="1.0" encoding="utf-8"?><D:multistatus xmlns:D="DAV:">`)
+		for _, name := range names {
+			fmt.Fprintf(w, `<D:response><D:href>%s</D:href></D:response>`, name)
+		}
+		io.WriteString(w, `</D:multistatus>`)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newMockWebDAVServer(t *testing.T, files map[string]string) (*mockWebDAVServer, string) {
+	t.Setenv("REMBLOB_DAV_USER", "user")
+	t.Setenv("REMBLOB_DAV_PASSWORD", "secret")
+
+	mock := &mockWebDAVServer{files: files, modified: time.Now(), user: "user", password: "secret"}
+	server := httptest.NewServer(mock)
+	t.Cleanup(server.Close)
+
+	return mock, strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestWebDAVStorageReadWrite(t *testing.T) {
+	mock, host := newMockWebDAVServer(t, map[string]string{"/dir/a.txt": "test"})
+
+	src := getWebDAVFileStorage(mustStrToURI(t, "dav://"+host+"/dir/a.txt"), http.DefaultClient)
+	assert.Equal(t, "test", mustReadAll(t, src))
+	assert.NoError(t, src.Close())
+
+	dst := getWebDAVFileStorage(mustStrToURI(t, "dav://"+host+"/dir/b.txt"), http.DefaultClient)
+	_, err := dst.Write([]byte("changed"))
+	assert.NoError(t, err)
+	assert.NoError(t, dst.Close())
+	assert.Equal(t, "changed", mock.files["/dir/b.txt"])
+
+	exists, err := dst.Exists()
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	missing := getWebDAVFileStorage(mustStrToURI(t, "dav://"+host+"/dir/missing.txt"), http.DefaultClient)
+	exists, err = missing.Exists()
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	_, err = io.ReadAll(missing)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "404")
+}
+
+func TestWebDAVStorageCredentials(t *testing.T) {
+	_, host := newMockWebDAVServer(t, map[string]string{"/a.txt": "test"})
+	t.Setenv("REMBLOB_DAV_PASSWORD", "wrong")
+
+	fs := getWebDAVFileStorage(mustStrToURI(t, "dav://"+host+"/a.txt"), http.DefaultClient)
+	_, err := io.ReadAll(fs)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "401")
+}
+
+func TestWebDAVStorageIfModifiedSince(t *testing.T) {
+	mock, host := newMockWebDAVServer(t, map[string]string{"/a.txt": "test"})
+
+	fs := getWebDAVFileStorage(mustStrToURI(t, "dav://"+host+"/a.txt"), http.DefaultClient)
+	fs.ifModifiedSince = mock.modified.Add(time.Hour)
+	_, err := io.ReadAll(fs)
+
+	assert.ErrorIs(t, err, ErrNotModified)
+}
+
+func TestWebDAVStorageSuggestions(t *testing.T) {
+	_, host := newMockWebDAVServer(t, map[string]string{
+		"/a.txt":     "",
+		"/dir/b.txt": "",
+		"/dir/c.txt": "",
+	})
+
+	cases := []struct {
+		prefix   string
+		expected []string
+	}{
+		{prefix: "dav://" + host + "/", expected: []string{"dav://" + host + "/a.txt"}},
+		{prefix: "dav://" + host + "/dir/", expected: []string{"dav://" + host + "/dir/b.txt", "dav://" + host + "/dir/c.txt"}},
+		{prefix: "dav://" + host + "/dir/b", expected: []string{"dav://" + host + "/dir/b.txt", "dav://" + host + "/dir/c.txt"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.prefix, func(t *testing.T) {
+			suggestions := webdavFileStorageLister(mustStrToURI(t, tc.prefix), http.DefaultClient)
+			assert.Equal(t, tc.expected, urisToPaths(suggestions))
+		})
+	}
+}